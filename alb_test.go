@@ -0,0 +1,126 @@
+package apig_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+var testALBRequest string = `{
+  "requestContext": {
+    "elb": {
+      "targetGroupArn": "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/lambda-279XGJDqGZ5rsrHC2Fjr/49e9d65c45c6791a"
+    }
+  },
+  "httpMethod": "GET",
+  "path": "/lambda",
+  "queryStringParameters": {
+    "query": "1234ABCD"
+  },
+  "headers": {
+    "accept": "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8",
+    "accept-encoding": "gzip",
+    "accept-language": "en-US,en;q=0.9",
+    "connection": "keep-alive",
+    "host": "lambda-alb-123578498.us-east-1.elb.amazonaws.com",
+    "upgrade-insecure-requests": "1",
+    "user-agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/71.0.3578.98 Safari/537.36",
+    "x-amzn-trace-id": "Root=1-5c536348-3d683b8b04734faae651f476",
+    "x-forwarded-for": "72.12.164.125",
+    "x-forwarded-port": "80",
+    "x-forwarded-proto": "http",
+    "x-imforwards": "20"
+  },
+  "body": "",
+  "isBase64Encoded": false
+}`
+
+var testALBMultiValueRequest string = `{
+  "requestContext": {
+    "elb": {
+      "targetGroupArn": "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/lambda-279XGJDqGZ5rsrHC2Fjr/49e9d65c45c6791a"
+    }
+  },
+  "httpMethod": "POST",
+  "path": "/lambda",
+  "multiValueQueryStringParameters": {
+    "myKey": ["val1", "val2"]
+  },
+  "multiValueHeaders": {
+    "host": ["lambda-alb-123578498.us-east-1.elb.amazonaws.com"],
+    "cookie": ["name1=value1", "name2=value2"],
+    "x-forwarded-for": ["72.12.164.125, 10.0.0.1"],
+    "x-forwarded-proto": ["https"]
+  },
+  "body": "{\"test\":\"body\"}",
+  "isBase64Encoded": false
+}`
+
+func TestToStdLibRequestALB(t *testing.T) {
+	req := events.ALBTargetGroupRequest{}
+	require.NoError(t, json.Unmarshal([]byte(testALBRequest), &req))
+
+	stReq, err := apig.ToStdLibRequestALB(req)
+	require.NoError(t, err)
+	require.Equal(t, "http://lambda-alb-123578498.us-east-1.elb.amazonaws.com/lambda?query=1234ABCD", stReq.URL.String())
+	require.Equal(t, "72.12.164.125", stReq.RemoteAddr)
+	require.Equal(t, "gzip", stReq.Header.Get("Accept-Encoding"))
+
+	req = events.ALBTargetGroupRequest{}
+	require.NoError(t, json.Unmarshal([]byte(testALBMultiValueRequest), &req))
+
+	stReq, err = apig.ToStdLibRequestALB(req)
+	require.NoError(t, err)
+	require.Equal(t, []string{"val1", "val2"}, stReq.URL.Query()["myKey"])
+	require.Len(t, stReq.Cookies(), 2)
+	require.Equal(t, "72.12.164.125", stReq.RemoteAddr)
+	b, err := ioutil.ReadAll(stReq.Body)
+	require.NoError(t, err)
+	require.Equal(t, "{\"test\":\"body\"}", string(b))
+}
+
+func TestServeALBHeaderModes(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.SetCookie(rw, &http.Cookie{Name: "a", Value: "1"})
+		http.SetCookie(rw, &http.Cookie{Name: "b", Value: "2"})
+		rw.Write([]byte("ok"))
+	})
+
+	req := events.ALBTargetGroupRequest{}
+	require.NoError(t, json.Unmarshal([]byte(testALBRequest), &req))
+	resp, err := apig.ServeALB(req, handler)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "200 OK", resp.StatusDescription)
+	require.Equal(t, "ok", resp.Body)
+	require.Nil(t, resp.MultiValueHeaders)
+	require.Equal(t, "a=1", resp.Headers["set-cookie"])
+	require.Equal(t, "b=2", resp.Headers["SET-COOKIE"])
+
+	req = events.ALBTargetGroupRequest{}
+	require.NoError(t, json.Unmarshal([]byte(testALBMultiValueRequest), &req))
+	resp, err = apig.ServeALB(req, handler)
+	require.NoError(t, err)
+	require.Nil(t, resp.Headers)
+	require.Equal(t, []string{"a=1", "b=2"}, resp.MultiValueHeaders["Set-Cookie"])
+}
+
+func TestLambdaHandlerDetectsALB(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+
+	resp, err := apig.LambdaHandler(handler, nil)(json.RawMessage(testALBRequest))
+	require.NoError(t, err)
+	require.IsType(t, events.ALBTargetGroupResponse{}, resp)
+	require.Equal(t, http.StatusTeapot, resp.(events.ALBTargetGroupResponse).StatusCode)
+
+	resp, err = apig.LambdaHandler(handler, nil)(json.RawMessage(testRequest))
+	require.NoError(t, err)
+	require.IsType(t, events.APIGatewayProxyResponse{}, resp)
+}
@@ -5,11 +5,11 @@ package apig
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"unicode"
@@ -35,6 +35,7 @@ func SetLogger(l *slogger.SpalkLogger) {
 }
 
 //Respond will produce a response that will get formatted such that apigateway will modify it's response to the browser
+//When err is set and body is nil the response is a problem+json document for err, see HTTPError
 func Respond(body interface{}, status int, req events.APIGatewayProxyRequest, err error) (events.APIGatewayProxyResponse, error) {
 	bodyBytes, jsonerr := json.Marshal(body)
 	if jsonerr != nil {
@@ -44,31 +45,37 @@ func Respond(body interface{}, status int, req events.APIGatewayProxyRequest, er
 		StatusCode: status,
 		Body:       fmt.Sprintf("%s", bodyBytes),
 	}
+	contentType := "application/json"
 	if err != nil {
-		if status == http.StatusOK {
-			resp.StatusCode = http.StatusInternalServerError
-		}
-		if body == nil {
-			resp.Body = err.Error()
-		}
-		logger.Println(err.Error())
+		resp.StatusCode, resp.Body, contentType = errorResponse(body, resp.Body, status, req.Path, err)
 	}
-	if status == 0 {
+	if resp.StatusCode == 0 {
 		resp.StatusCode = http.StatusOK
 	}
 	if resp.Body == "null" {
 		resp.Body = ""
 	}
-	resp.Headers = map[string]string{
-		"Access-Control-Allow-Origin":  "*",
-		"Access-Control-Allow-Methods": "DELETE,GET,HEAD,OPTIONS,PATCH,POST,PUT",
-		"Access-Control-Allow-Headers": "Content-Type,Authorization,X-Amz-Date,X-Api-Key,X-Amz-Security-Token",
-		"Content-Type":                 "application/json",
-	}
+	resp.Headers = corsConfig.forPath(req.Path).headers(headerValue(req.Headers, "Origin"), false)
+	resp.Headers["Content-Type"] = contentType
 	return resp, nil
 }
 
+//errorResponse returns the status, body and content type to respond to err with
+//The body is a problem document for err unless a body was given, which is sent as is
+func errorResponse(body interface{}, bodyJSON string, status int, instance string, err error) (int, string, string) {
+	problem := problemFor(err, status, instance)
+	if body != nil {
+		return problem.Status, bodyJSON, "application/json"
+	}
+	problemBytes, jsonerr := json.Marshal(problem)
+	if jsonerr != nil {
+		logger.Println(jsonerr.Error())
+	}
+	return problem.Status, string(problemBytes), problemContentType
+}
+
 //RespondV2 will produce a response that will get formatted such that apigateway will modify it's response to the browser
+//When err is set and body is nil the response is a problem+json document for err, see HTTPError
 func RespondV2(body interface{}, status int, req events.APIGatewayV2HTTPRequest, err error) (events.APIGatewayV2HTTPResponse, error) {
 	bodyBytes, jsonerr := json.Marshal(body)
 	if jsonerr != nil {
@@ -78,41 +85,29 @@ func RespondV2(body interface{}, status int, req events.APIGatewayV2HTTPRequest,
 		StatusCode: status,
 		Body:       fmt.Sprintf("%s", bodyBytes),
 	}
+	contentType := "application/json"
 	if err != nil {
-		if status == http.StatusOK {
-			resp.StatusCode = http.StatusInternalServerError
-		}
-		if body == nil {
-			resp.Body = err.Error()
-		}
-		logger.Println(err.Error())
+		resp.StatusCode, resp.Body, contentType = errorResponse(body, resp.Body, status, req.RawPath, err)
 	}
-	if status == 0 {
+	if resp.StatusCode == 0 {
 		resp.StatusCode = http.StatusOK
 	}
 	if resp.Body == "null" {
 		resp.Body = ""
 	}
-	resp.Headers = map[string]string{
-		"Access-Control-Allow-Origin":  "*",
-		"Access-Control-Allow-Methods": "DELETE,GET,HEAD,OPTIONS,PATCH,POST,PUT",
-		"Access-Control-Allow-Headers": "Content-Type,Authorization,X-Amz-Date,X-Api-Key,X-Amz-Security-Token",
-		"Content-Type":                 "application/json",
-	}
+	resp.Headers = corsConfig.forPath(req.RawPath).headers(headerValue(req.Headers, "Origin"), false)
+	resp.Headers["Content-Type"] = contentType
 	return resp, nil
 }
 
 //RespondHTTP will marshall the response body as JSON and write it to the response writer
 //This function signature was chosen to make it substitutable for http.Error
 //This does not end the requset, but does write the header. Care should be taken to close the response after this has been called
+//An error body is written as a problem+json document, see HTTPError
 func RespondHTTP(rw http.ResponseWriter, body interface{}, status int) {
 	if body != nil {
 		if err, ok := body.(error); ok {
-			if status < 400 {
-				status = http.StatusInternalServerError
-			}
-			logger.Printf("Writing %v", err.Error())
-			http.Error(rw, err.Error(), status)
+			writeProblem(rw, problemFor(err, status, ""))
 			return
 		}
 		var bodyBytes []byte
@@ -154,6 +149,8 @@ type ResponseWriterV2 struct {
 	resp   events.APIGatewayV2HTTPResponse
 	body   bytes.Buffer
 	header http.Header
+	//written counts the body bytes, to check the response fits in lambda's payload limit without encoding it
+	written int
 }
 
 //Header returns the map that will be sent with WriteHeader
@@ -165,7 +162,9 @@ func (rw *ResponseWriterV2) Header() http.Header {
 }
 
 func (rw *ResponseWriterV2) Write(data []byte) (int, error) {
-	return rw.body.Write(data)
+	n, err := rw.body.Write(data)
+	rw.written += n
+	return n, err
 }
 
 //WriteHeader sets the response code in the embeded response object
@@ -175,20 +174,45 @@ func (rw *ResponseWriterV2) WriteHeader(status int) {
 	//can't actually write the headers out before we return :(
 }
 
+func (rw *ResponseWriterV2) reset() {
+	rw.resp = events.APIGatewayV2HTTPResponse{}
+	rw.body.Reset()
+	rw.header = nil
+	rw.written = 0
+}
+
 //GetResponse formats the net/http response to how the response is expected by apigateway
+//Set-Cookie headers are returned in the cookies field of the response
+//The status defaults to 200 when the handler doesn't call WriteHeader, as it does with ServeStream
 func (rw *ResponseWriterV2) GetResponse() (events.APIGatewayV2HTTPResponse, error) {
-	rw.resp.Body = rw.body.String()
-	rw.resp.Headers = make(map[string]string, len(rw.header))
-	for key, values := range rw.header {
+	if rw.resp.StatusCode == 0 {
+		rw.resp.StatusCode = http.StatusOK
+	}
+	rw.resp.Body, rw.resp.IsBase64Encoded = encodeBody(rw.header, rw.body.Bytes())
+	rw.resp.Headers, rw.resp.Cookies = v2Headers(rw.header)
+	return rw.resp, nil
+}
+
+//v2Headers splits headers into the headers and cookies of a payload format 2.0 response
+func v2Headers(header http.Header) (map[string]string, []string) {
+	headers := make(map[string]string, len(header))
+	var cookies []string
+	for key, values := range header {
 		if strings.ToLower(key) == "set-cookie" {
-			for i, v := range values {
-				rw.resp.Headers[setCookieCasing(i)] = v
-			}
+			cookies = append(cookies, values...)
 		} else {
-			rw.resp.Headers[key] = strings.Join(values, ",")
+			headers[key] = strings.Join(values, ",")
 		}
 	}
-	return rw.resp, nil
+	return headers, cookies
+}
+
+var singleValueHeaders bool
+
+//SetSingleValueHeaders makes ResponseWriter return single value headers, for stacks that predate multi value header support
+//Repeated headers are joined with "," and multiple Set-Cookie headers are sent using differently cased header names
+func SetSingleValueHeaders(enabled bool) {
+	singleValueHeaders = enabled
 }
 
 //ResponseWriter implements the net/http ResponseWriter interface for using stdlib compliant server libraries with apigateway and lambdas
@@ -196,6 +220,8 @@ type ResponseWriter struct {
 	resp   events.APIGatewayProxyResponse
 	body   bytes.Buffer
 	header http.Header
+	//written counts the body bytes, to check the response fits in lambda's payload limit without encoding it
+	written int
 }
 
 //Header returns the map that will be sent with WriteHeader
@@ -207,7 +233,9 @@ func (rw *ResponseWriter) Header() http.Header {
 }
 
 func (rw *ResponseWriter) Write(data []byte) (int, error) {
-	return rw.body.Write(data)
+	n, err := rw.body.Write(data)
+	rw.written += n
+	return n, err
 }
 
 //WriteHeader sets the response code in the embeded response object
@@ -217,9 +245,88 @@ func (rw *ResponseWriter) WriteHeader(status int) {
 	//can't actually write the headers out before we return :(
 }
 
+func (rw *ResponseWriter) reset() {
+	rw.resp = events.APIGatewayProxyResponse{}
+	rw.body.Reset()
+	rw.header = nil
+	rw.written = 0
+}
+
 //GetResponse formats the net/http response to how the response is expected by apigateway
+//Headers are returned as multi value headers, unless SetSingleValueHeaders has been enabled
+//The status defaults to 200 when the handler doesn't call WriteHeader, as it does with ServeStream
 func (rw *ResponseWriter) GetResponse() (events.APIGatewayProxyResponse, error) {
-	rw.resp.Body = rw.body.String()
+	if rw.resp.StatusCode == 0 {
+		rw.resp.StatusCode = http.StatusOK
+	}
+	rw.resp.Body, rw.resp.IsBase64Encoded = encodeBody(rw.header, rw.body.Bytes())
+	if !singleValueHeaders {
+		rw.resp.MultiValueHeaders = make(map[string][]string, len(rw.header))
+		for key, values := range rw.header {
+			rw.resp.MultiValueHeaders[key] = values
+		}
+		return rw.resp, nil
+	}
+	rw.resp.Headers = make(map[string]string, len(rw.header))
+	for key, values := range rw.header {
+		if strings.ToLower(key) == "set-cookie" {
+			for i, v := range values {
+				rw.resp.Headers[setCookieCasing(i)] = v
+			}
+		} else {
+			rw.resp.Headers[key] = strings.Join(values, ",")
+		}
+	}
+	return rw.resp, nil
+}
+
+//ResponseWriterALB implements the net/http ResponseWriter interface for using stdlib compliant server libraries with application load balancers and lambdas
+type ResponseWriterALB struct {
+	resp   events.ALBTargetGroupResponse
+	body   bytes.Buffer
+	header http.Header
+	//multiValueHeaders is set when the target group has multi value headers enabled, which requires the response to use them as well
+	multiValueHeaders bool
+}
+
+//Header returns the map that will be sent with WriteHeader
+func (rw *ResponseWriterALB) Header() http.Header {
+	if rw.header == nil {
+		rw.header = make(map[string][]string)
+	}
+	return rw.header
+}
+
+func (rw *ResponseWriterALB) Write(data []byte) (int, error) {
+	return rw.body.Write(data)
+}
+
+//WriteHeader sets the response code in the embeded response object
+//To be compliant with the http spec for the interface it should write the headers to the client, but we can't control that
+func (rw *ResponseWriterALB) WriteHeader(status int) {
+	rw.resp.StatusCode = status
+}
+
+func (rw *ResponseWriterALB) reset() {
+	rw.resp = events.ALBTargetGroupResponse{}
+	rw.body.Reset()
+	rw.header = nil
+}
+
+//GetResponse formats the net/http response to how the response is expected by the load balancer
+func (rw *ResponseWriterALB) GetResponse() (events.ALBTargetGroupResponse, error) {
+	if rw.resp.StatusCode == 0 {
+		rw.resp.StatusCode = http.StatusOK
+	}
+	rw.resp.StatusDescription = fmt.Sprintf("%d %s", rw.resp.StatusCode, http.StatusText(rw.resp.StatusCode))
+	rw.resp.Body, rw.resp.IsBase64Encoded = encodeBody(rw.header, rw.body.Bytes())
+	if rw.multiValueHeaders {
+		rw.resp.MultiValueHeaders = make(map[string][]string, len(rw.header))
+		for key, values := range rw.header {
+			rw.resp.MultiValueHeaders[key] = values
+		}
+		return rw.resp, nil
+	}
 	rw.resp.Headers = make(map[string]string, len(rw.header))
 	for key, values := range rw.header {
 		if strings.ToLower(key) == "set-cookie" {
@@ -264,26 +371,82 @@ func toggleCase(a byte) string {
 }
 
 //ServeV2 handles and responds to the requests using a net/http handler
+//If the handler panics the response is replaced with a 500, see SetPanicHandler
 func ServeV2(req events.APIGatewayV2HTTPRequest, handler http.Handler) (events.APIGatewayV2HTTPResponse, error) {
+	return ServeV2WithContext(context.Background(), req, handler)
+}
+
+//ServeV2WithContext handles and responds to the requests using a net/http handler
+//The request's context is derived from ctx and carries the event, see V2RequestContextFrom and PathParameters
+func ServeV2WithContext(ctx context.Context, req events.APIGatewayV2HTTPRequest, handler http.Handler) (events.APIGatewayV2HTTPResponse, error) {
 	shr, err := ToStdLibRequestV2(req)
 	if err != nil {
 		logger.Println(err.Error())
 		return RespondV2(nil, http.StatusInternalServerError, req, err)
 	}
+	shr = shr.WithContext(withEvent(ctx, req))
 	rw := ResponseWriterV2{}
-	handler.ServeHTTP(&rw, shr)
-	return rw.GetResponse()
+	withCORS(withRecovery(handler)).ServeHTTP(&rw, shr)
+	resp, err := rw.GetResponse()
+	if err != nil {
+		return resp, err
+	}
+	if size := responseSize(resp, rw.header, rw.written, resp.IsBase64Encoded); size > awsLambdaMaxBodySize {
+		replacement := checkOverflow(shr, ToStdLibResponseV2(resp), size)
+		if resp, err = ToApigResponseV2(replacement); err == nil && payloadSize(resp) > awsLambdaMaxBodySize {
+			resp, err = ToApigResponseV2(rejectOversized(shr, replacement))
+		}
+	}
+	return resp, err
 }
 
 //Serve handles and responds to the requests using a net/http handler
+//If the handler panics the response is replaced with a 500, see SetPanicHandler
 func Serve(req events.APIGatewayProxyRequest, handler http.Handler) (events.APIGatewayProxyResponse, error) {
+	return ServeWithContext(context.Background(), req, handler)
+}
+
+//ServeWithContext handles and responds to the requests using a net/http handler
+//The request's context is derived from ctx and carries the event, see RequestContextFrom and PathParameters
+func ServeWithContext(ctx context.Context, req events.APIGatewayProxyRequest, handler http.Handler) (events.APIGatewayProxyResponse, error) {
 	shr, err := ToStdLibRequest(req)
 	if err != nil {
 		logger.Println(err.Error())
 		return Respond(nil, http.StatusInternalServerError, req, err)
 	}
+	shr = shr.WithContext(withEvent(ctx, req))
 	rw := ResponseWriter{}
-	handler.ServeHTTP(&rw, shr)
+	withCORS(withRecovery(handler)).ServeHTTP(&rw, shr)
+	resp, err := rw.GetResponse()
+	if err != nil {
+		return resp, err
+	}
+	if size := responseSize(resp, rw.header, rw.written, resp.IsBase64Encoded); size > awsLambdaMaxBodySize {
+		replacement := checkOverflow(shr, ToStdLibResponse(resp), size)
+		if resp, err = ToApigResponse(replacement); err == nil && payloadSize(resp) > awsLambdaMaxBodySize {
+			resp, err = ToApigResponse(rejectOversized(shr, replacement))
+		}
+	}
+	return resp, err
+}
+
+//ServeALB handles and responds to application load balancer requests using a net/http handler
+//If the handler panics the response is replaced with a 500, see SetPanicHandler
+func ServeALB(req events.ALBTargetGroupRequest, handler http.Handler) (events.ALBTargetGroupResponse, error) {
+	return ServeALBWithContext(context.Background(), req, handler)
+}
+
+//ServeALBWithContext handles and responds to application load balancer requests using a net/http handler
+//The request's context is derived from ctx and carries the event, see EventFrom
+func ServeALBWithContext(ctx context.Context, req events.ALBTargetGroupRequest, handler http.Handler) (events.ALBTargetGroupResponse, error) {
+	rw := ResponseWriterALB{multiValueHeaders: req.MultiValueHeaders != nil}
+	shr, err := ToStdLibRequestALB(req)
+	if err != nil {
+		writeProblem(&rw, problemFor(err, http.StatusInternalServerError, req.Path))
+		return rw.GetResponse()
+	}
+	shr = shr.WithContext(withEvent(ctx, req))
+	withCORS(withRecovery(handler)).ServeHTTP(&rw, shr)
 	return rw.GetResponse()
 }
 
@@ -296,9 +459,7 @@ func StartApex(handler http.Handler) {
 			logger.Println(string(event))
 			return Respond(nil, 401, req, err)
 		}
-		for k, v := range req.StageVariables {
-			os.Setenv(k, v)
-		}
+		exportStageVariables(req.StageVariables)
 		resp, err := Serve(req, handler)
 		if err != nil {
 			logger.Println(err.Error())
@@ -307,31 +468,130 @@ func StartApex(handler http.Handler) {
 	})
 }
 
-//StartLambda ...
+//StartLambda starts the aws lambda runtime, serving events with LambdaHandlerWithContext
+//The lambda context (deadline, aws request id etc) is available from the http request's context
 func StartLambda(handler http.Handler, fallback lambdaHandlerFunc) {
-	lambda.Start(LambdaHandler(handler, fallback))
+	lambda.Start(LambdaHandlerWithContext(handler, withoutContext(fallback)))
 }
 
 type lambdaHandlerFunc func(event json.RawMessage) (interface{}, error)
 
-//LambdaHandler ...
+type lambdaContextHandlerFunc func(ctx context.Context, event json.RawMessage) (interface{}, error)
+
+//withoutContext adapts a fallback that doesn't take a context
+func withoutContext(fallback lambdaHandlerFunc) lambdaContextHandlerFunc {
+	if fallback == nil {
+		return nil
+	}
+	return func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		return fallback(event)
+	}
+}
+
+//eventKind is the type of event the lambda was invoked with
+type eventKind int
+
+const (
+	unknownEvent eventKind = iota
+	proxyEvent
+	v2HTTPEvent
+	albEvent
+	webSocketEvent
+)
+
+//eventProbe picks out the fields used to tell which kind of event the lambda was invoked with
+type eventProbe struct {
+	Version        string `json:"version"`
+	Path           string `json:"path"`
+	RawPath        string `json:"rawPath"`
+	RequestContext struct {
+		ELB          *json.RawMessage `json:"elb"`
+		HTTP         *json.RawMessage `json:"http"`
+		EventType    string           `json:"eventType"`
+		ConnectionID string           `json:"connectionId"`
+	} `json:"requestContext"`
+}
+
+//detectEvent works out which payload format an event is in
+//REST APIs and HTTP APIs using payload format 1.0 send proxy events, HTTP APIs using payload format 2.0 and lambda function urls send v2 events
+func detectEvent(event json.RawMessage) eventKind {
+	var probe eventProbe
+	if err := json.Unmarshal(event, &probe); err != nil {
+		return unknownEvent
+	}
+	switch {
+	case probe.RequestContext.ELB != nil:
+		return albEvent
+	case probe.RequestContext.EventType != "" && probe.RequestContext.ConnectionID != "":
+		return webSocketEvent
+	case probe.Version == "2.0" || (probe.RequestContext.HTTP != nil && probe.RawPath != ""):
+		return v2HTTPEvent
+	case probe.Path != "":
+		return proxyEvent
+	}
+	return unknownEvent
+}
+
+//LambdaHandler returns a lambda handler that serves apigateway (payload format 1.0 and 2.0), lambda function url and load balancer events using the http handler
+//Websocket api events are served with ServeWebSocket when the handler has a WebSocketHandler, see WithWebSocket
+//Any other event is passed to the fallback
+//Panics in the http handler are recovered and answered with a 500, see SetPanicHandler
 func LambdaHandler(handler http.Handler, fallback lambdaHandlerFunc) lambdaHandlerFunc {
+	h := LambdaHandlerWithContext(handler, withoutContext(fallback))
 	return func(event json.RawMessage) (interface{}, error) {
+		return h(context.Background(), event)
+	}
+}
+
+//LambdaHandlerWithContext is LambdaHandler for use with lambda.Start, the lambda context is passed on to the http handler and the fallback
+func LambdaHandlerWithContext(handler http.Handler, fallback lambdaContextHandlerFunc) lambdaContextHandlerFunc {
+	return func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		var resp interface{}
 		var err error
-		var apigEvent events.APIGatewayProxyRequest
-		if err = json.Unmarshal(event, &apigEvent); err == nil && apigEvent.Path != "" {
-			for k, v := range apigEvent.StageVariables {
-				os.Setenv(k, v)
+		switch detectEvent(event) {
+		case albEvent:
+			var albEvent events.ALBTargetGroupRequest
+			if err = json.Unmarshal(event, &albEvent); err != nil {
+				return nil, err
 			}
-			resp, err := Serve(apigEvent, handler)
-			if err != nil {
-				logger.Println(err.Error())
+			resp, err = ServeALBWithContext(ctx, albEvent, handler)
+		case v2HTTPEvent:
+			var v2Event events.APIGatewayV2HTTPRequest
+			if err = json.Unmarshal(event, &v2Event); err != nil {
+				return nil, err
 			}
-			return resp, err
+			exportStageVariables(v2Event.StageVariables)
+			resp, err = ServeV2WithContext(ctx, v2Event, handler)
+		case proxyEvent:
+			var apigEvent events.APIGatewayProxyRequest
+			if err = json.Unmarshal(event, &apigEvent); err != nil {
+				return nil, err
+			}
+			exportStageVariables(apigEvent.StageVariables)
+			resp, err = ServeWithContext(ctx, apigEvent, handler)
+		case webSocketEvent:
+			ws := webSocketHandlerFor(handler)
+			if ws == nil {
+				if fallback != nil {
+					return fallback(ctx, event)
+				}
+				return nil, ErrNoHandler
+			}
+			var wsEvent events.APIGatewayWebsocketProxyRequest
+			if err = json.Unmarshal(event, &wsEvent); err != nil {
+				return nil, err
+			}
+			exportStageVariables(wsEvent.StageVariables)
+			resp, err = ServeWebSocket(ctx, wsEvent, ws)
+		default:
+			if fallback != nil {
+				return fallback(ctx, event)
+			}
+			return nil, ErrNoHandler
 		}
-		if fallback != nil {
-			return fallback(event)
+		if err != nil {
+			logger.Println(err.Error())
 		}
-		return nil, ErrNoHandler
+		return resp, err
 	}
 }
@@ -84,7 +84,8 @@ func TestRequestParsedCorrectly(t *testing.T) {
 	b, err := ioutil.ReadAll(stReq.Body)
 	require.NoError(t, err)
 	require.Equal(t, "{\"test\":\"body\"}", string(b))
-	require.Equal(t, "1234567890.execute-api.us-east-1.amazonaws.com/prod", stReq.Host)
+	require.Equal(t, "1234567890.execute-api.us-east-1.amazonaws.com", stReq.Host)
+	require.Equal(t, "prod", apig.Stage(stReq))
 }
 
 func TestToStdLibRequestMultiQuery(t *testing.T) {
@@ -150,7 +151,7 @@ func TestToStdLibRequestMultiQuery(t *testing.T) {
 	require.NoError(t, err)
 	stReq, err := apig.ToStdLibRequest(req)
 	require.NoError(t, err)
-	require.Equal(t, "https://1234567890.execute-api.us-east-1.amazonaws.com%2Fprod/path/to/resource?petType=dog&petType=fish", stReq.URL.String())
+	require.Equal(t, "https://1234567890.execute-api.us-east-1.amazonaws.com/path/to/resource?petType=dog&petType=fish", stReq.URL.String())
 }
 
 func TestToStdLibRequestV2MultiQuery(t *testing.T) {
@@ -158,7 +159,7 @@ func TestToStdLibRequestV2MultiQuery(t *testing.T) {
     "version": "2.0",
     "routeKey": "$default",
     "rawPath": "/my/path",
-    "rawQueryString": "parameter1=value1&parameter1=value2&parameter2=value",
+    "rawQueryString": "petType=cat&petType=dog&parameter2=value",
     "cookies": [
       "cookie1",
       "cookie2"
@@ -261,3 +262,125 @@ func TestRespondHttpResponseBodyTooLarge(t *testing.T) {
 	require.Equal(t, "Response body too large\n", rw.Body.String())
 	require.True(t, tNot.GotNotification("Response body too large"))
 }
+
+var testFunctionURLRequest string = `{
+  "version": "2.0",
+  "routeKey": "$default",
+  "rawPath": "/my/path",
+  "rawQueryString": "parameter1=value1&parameter1=value2&parameter2=value",
+  "headers": {
+    "header1": "value1",
+    "host": "abcdefg.lambda-url.us-east-1.on.aws"
+  },
+  "requestContext": {
+    "accountId": "123456789012",
+    "apiId": "abcdefg",
+    "domainName": "abcdefg.lambda-url.us-east-1.on.aws",
+    "domainPrefix": "abcdefg",
+    "http": {
+      "method": "POST",
+      "path": "/my/path",
+      "protocol": "HTTP/1.1",
+      "sourceIp": "123.123.123.123",
+      "userAgent": "agent"
+    },
+    "requestId": "id",
+    "routeKey": "$default",
+    "stage": "$default",
+    "time": "12/Mar/2020:19:03:58 +0000",
+    "timeEpoch": 1583348638390
+  },
+  "body": "Hello from client!",
+  "isBase64Encoded": false
+}`
+
+func TestLambdaHandlerDetectsPayloadVersion(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		rw.Write(append([]byte(r.Method+" "+r.URL.Path+" "), b...))
+	})
+	fallbackCalled := false
+	fallback := func(event json.RawMessage) (interface{}, error) {
+		fallbackCalled = true
+		return "fallback", nil
+	}
+
+	resp, err := apig.LambdaHandler(handler, fallback)(json.RawMessage(testRequest))
+	require.NoError(t, err)
+	require.IsType(t, events.APIGatewayProxyResponse{}, resp)
+	require.Equal(t, "POST /path/to/resource {\"test\":\"body\"}", resp.(events.APIGatewayProxyResponse).Body)
+
+	resp, err = apig.LambdaHandler(handler, fallback)(json.RawMessage(testFunctionURLRequest))
+	require.NoError(t, err)
+	require.IsType(t, events.APIGatewayV2HTTPResponse{}, resp)
+	require.Equal(t, "POST /my/path Hello from client!", resp.(events.APIGatewayV2HTTPResponse).Body)
+	require.False(t, fallbackCalled)
+
+	resp, err = apig.LambdaHandler(handler, fallback)(json.RawMessage(`{"Records":[]}`))
+	require.NoError(t, err)
+	require.Equal(t, "fallback", resp)
+	require.True(t, fallbackCalled)
+
+	_, err = apig.LambdaHandler(handler, nil)(json.RawMessage(`"not an object"`))
+	require.Equal(t, apig.ErrNoHandler, err)
+}
+
+func TestServeMultiValueHeaders(t *testing.T) {
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: http.MethodGet,
+		Path:       "/",
+		Headers:    map[string]string{"Accept": "text/html"},
+		MultiValueHeaders: map[string][]string{
+			"Accept": {"application/json", "text/html"},
+		},
+	}
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.Equal(t, []string{"application/json", "text/html"}, r.Header["Accept"])
+		http.SetCookie(rw, &http.Cookie{Name: "a", Value: "1"})
+		http.SetCookie(rw, &http.Cookie{Name: "b", Value: "2"})
+		rw.Header().Add("Vary", "Accept")
+		rw.Header().Add("Vary", "Origin")
+	})
+
+	resp, err := apig.Serve(req, handler)
+	require.NoError(t, err)
+	require.Nil(t, resp.Headers)
+	require.Equal(t, []string{"a=1", "b=2"}, resp.MultiValueHeaders["Set-Cookie"])
+	require.Equal(t, []string{"Accept", "Origin"}, resp.MultiValueHeaders["Vary"])
+	stResp := apig.ToStdLibResponse(resp)
+	require.Len(t, stResp.Cookies(), 2)
+
+	apig.SetSingleValueHeaders(true)
+	defer apig.SetSingleValueHeaders(false)
+	resp, err = apig.Serve(req, handler)
+	require.NoError(t, err)
+	require.Nil(t, resp.MultiValueHeaders)
+	require.Equal(t, "Accept,Origin", resp.Headers["Vary"])
+	require.Equal(t, "a=1", resp.Headers["set-cookie"])
+	require.Equal(t, "b=2", resp.Headers["SET-COOKIE"])
+}
+
+func TestServeV2Cookies(t *testing.T) {
+	req := events.APIGatewayV2HTTPRequest{
+		RawPath: "/",
+		Cookies: []string{"session=abc", "theme=dark"},
+	}
+	req.RequestContext.HTTP.Method = http.MethodGet
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		session, err := r.Cookie("session")
+		require.NoError(t, err)
+		require.Equal(t, "abc", session.Value)
+		require.Len(t, r.Cookies(), 2)
+
+		http.SetCookie(rw, &http.Cookie{Name: "a", Value: "1"})
+		http.SetCookie(rw, &http.Cookie{Name: "b", Value: "2", HttpOnly: true})
+		rw.Header().Set("Content-Type", "text/plain")
+	})
+
+	resp, err := apig.ServeV2(req, handler)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a=1", "b=2; HttpOnly"}, resp.Cookies)
+	require.Equal(t, "text/plain", resp.Headers["Content-Type"])
+	require.NotContains(t, resp.Headers, "Set-Cookie")
+}
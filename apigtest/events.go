@@ -0,0 +1,519 @@
+package apigtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	testHost      = "apigtest.example.com"
+	testSourceIP  = "127.0.0.1"
+	testRequestID = "apigtest-request"
+	testAccountID = "123456789012"
+	testAPIID     = "apigtest"
+)
+
+//V1Request builds a payload format 1.0 event, as sent by rest apis
+type V1Request struct {
+	req events.APIGatewayProxyRequest
+}
+
+//NewV1Request starts building a rest api event for method and path, sent from stage "test"
+func NewV1Request(method, path string) *V1Request {
+	now := time.Now()
+	return &V1Request{req: events.APIGatewayProxyRequest{
+		Resource:          path,
+		Path:              path,
+		HTTPMethod:        method,
+		Headers:           map[string]string{"Host": testHost},
+		MultiValueHeaders: map[string][]string{"Host": {testHost}},
+		RequestContext: events.APIGatewayProxyRequestContext{
+			AccountID:        testAccountID,
+			APIID:            testAPIID,
+			Stage:            "test",
+			RequestID:        testRequestID,
+			HTTPMethod:       method,
+			Path:             path,
+			ResourcePath:     path,
+			RequestTime:      now.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+			RequestTimeEpoch: now.UnixNano() / int64(time.Millisecond),
+			Identity:         events.APIGatewayRequestIdentity{SourceIP: testSourceIP},
+		},
+	}}
+}
+
+//WithQuery adds query parameter values
+func (b *V1Request) WithQuery(key string, values ...string) *V1Request {
+	if b.req.MultiValueQueryStringParameters == nil {
+		b.req.QueryStringParameters = map[string]string{}
+		b.req.MultiValueQueryStringParameters = map[string][]string{}
+	}
+	b.req.MultiValueQueryStringParameters[key] = append(b.req.MultiValueQueryStringParameters[key], values...)
+	if all := b.req.MultiValueQueryStringParameters[key]; len(all) > 0 {
+		//apigateway puts the last value in queryStringParameters
+		b.req.QueryStringParameters[key] = all[len(all)-1]
+	}
+	return b
+}
+
+//WithHeader adds header values
+func (b *V1Request) WithHeader(key string, values ...string) *V1Request {
+	b.req.MultiValueHeaders[key] = append(b.req.MultiValueHeaders[key], values...)
+	if all := b.req.MultiValueHeaders[key]; len(all) > 0 {
+		b.req.Headers[key] = all[len(all)-1]
+	}
+	return b
+}
+
+//WithHost sets the Host header, which defaults to apigtest.example.com
+func (b *V1Request) WithHost(host string) *V1Request {
+	b.req.Headers["Host"] = host
+	b.req.MultiValueHeaders["Host"] = []string{host}
+	return b
+}
+
+//WithCookie adds a cookie to the Cookie header
+func (b *V1Request) WithCookie(name, value string) *V1Request {
+	b.req.Headers["Cookie"] = joinCookie(b.req.Headers["Cookie"], name, value)
+	b.req.MultiValueHeaders["Cookie"] = []string{b.req.Headers["Cookie"]}
+	return b
+}
+
+//WithResource sets the resource the event was routed to and its path parameters
+func (b *V1Request) WithResource(resource string, pathParameters map[string]string) *V1Request {
+	b.req.Resource = resource
+	b.req.RequestContext.ResourcePath = resource
+	b.req.PathParameters = pathParameters
+	return b
+}
+
+//WithStage sets the stage the event was sent from
+func (b *V1Request) WithStage(stage string) *V1Request {
+	b.req.RequestContext.Stage = stage
+	return b
+}
+
+//WithStageVariable adds a stage variable
+func (b *V1Request) WithStageVariable(key, value string) *V1Request {
+	if b.req.StageVariables == nil {
+		b.req.StageVariables = map[string]string{}
+	}
+	b.req.StageVariables[key] = value
+	return b
+}
+
+//WithAuthorizerClaims sets the claims of a cognito or jwt authorizer, as requestContext.authorizer.claims
+func (b *V1Request) WithAuthorizerClaims(claims map[string]interface{}) *V1Request {
+	b.req.RequestContext.Authorizer = map[string]interface{}{"claims": claims}
+	return b
+}
+
+//WithSourceIP sets the ip address of the caller
+func (b *V1Request) WithSourceIP(ip string) *V1Request {
+	b.req.RequestContext.Identity.SourceIP = ip
+	return b
+}
+
+//WithBody sets a text body
+func (b *V1Request) WithBody(body string) *V1Request {
+	b.req.Body, b.req.IsBase64Encoded = body, false
+	return b
+}
+
+//WithJSONBody sets the body to v encoded as json, and the Content-Type to application/json
+func (b *V1Request) WithJSONBody(v interface{}) *V1Request {
+	b.req.Headers["Content-Type"] = "application/json"
+	b.req.MultiValueHeaders["Content-Type"] = []string{"application/json"}
+	return b.WithBody(mustJSON(v))
+}
+
+//WithBase64Body sets a binary body, base64 encoded as apigateway does for binary media types
+func (b *V1Request) WithBase64Body(body []byte) *V1Request {
+	b.req.Body, b.req.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	return b
+}
+
+//Build returns the event
+func (b *V1Request) Build() events.APIGatewayProxyRequest {
+	return b.req
+}
+
+//JSON returns the event as it would be sent to a lambda handler
+func (b *V1Request) JSON() json.RawMessage {
+	return json.RawMessage(mustJSON(b.req))
+}
+
+//V2Request builds a payload format 2.0 event, as sent by http apis and function urls
+type V2Request struct {
+	req   events.APIGatewayV2HTTPRequest
+	query url.Values
+}
+
+//NewV2Request starts building an http api event for method and path, path should be escaped as it would be in a url
+func NewV2Request(method, path string) *V2Request {
+	now := time.Now()
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		unescaped = path
+	}
+	return &V2Request{
+		req: events.APIGatewayV2HTTPRequest{
+			Version:  "2.0",
+			RouteKey: "$default",
+			RawPath:  path,
+			Headers:  map[string]string{"host": testHost},
+			RequestContext: events.APIGatewayV2HTTPRequestContext{
+				AccountID:  testAccountID,
+				APIID:      testAPIID,
+				RouteKey:   "$default",
+				Stage:      "$default",
+				RequestID:  testRequestID,
+				DomainName: testHost,
+				Time:       now.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+				TimeEpoch:  now.UnixNano() / int64(time.Millisecond),
+				HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+					Method:   method,
+					Path:     unescaped,
+					Protocol: "HTTP/1.1",
+					SourceIP: testSourceIP,
+				},
+			},
+		},
+		query: url.Values{},
+	}
+}
+
+//WithQuery adds query parameter values
+func (b *V2Request) WithQuery(key string, values ...string) *V2Request {
+	for _, v := range values {
+		b.query.Add(key, v)
+	}
+	return b
+}
+
+//WithHeader adds header values, repeated values are joined with commas as http apis do
+func (b *V2Request) WithHeader(key string, values ...string) *V2Request {
+	key = strings.ToLower(key)
+	all := values
+	if existing, ok := b.req.Headers[key]; ok {
+		all = append([]string{existing}, values...)
+	}
+	b.req.Headers[key] = strings.Join(all, ",")
+	return b
+}
+
+//WithHost sets the host header and domain name, which default to apigtest.example.com
+func (b *V2Request) WithHost(host string) *V2Request {
+	b.req.Headers["host"] = host
+	b.req.RequestContext.DomainName = host
+	return b
+}
+
+//WithCookie adds a cookie to the cookies field
+func (b *V2Request) WithCookie(name, value string) *V2Request {
+	b.req.Cookies = append(b.req.Cookies, (&http.Cookie{Name: name, Value: value}).String())
+	return b
+}
+
+//WithRoute sets the route key the event matched and its path parameters
+func (b *V2Request) WithRoute(routeKey string, pathParameters map[string]string) *V2Request {
+	b.req.RouteKey = routeKey
+	b.req.RequestContext.RouteKey = routeKey
+	b.req.PathParameters = pathParameters
+	return b
+}
+
+//WithStage sets the stage the event was sent from
+func (b *V2Request) WithStage(stage string) *V2Request {
+	b.req.RequestContext.Stage = stage
+	return b
+}
+
+//WithStageVariable adds a stage variable
+func (b *V2Request) WithStageVariable(key, value string) *V2Request {
+	if b.req.StageVariables == nil {
+		b.req.StageVariables = map[string]string{}
+	}
+	b.req.StageVariables[key] = value
+	return b
+}
+
+//WithAuthorizerClaims sets the claims of a jwt authorizer, as requestContext.authorizer.jwt.claims
+func (b *V2Request) WithAuthorizerClaims(claims map[string]string) *V2Request {
+	b.req.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{Claims: claims},
+	}
+	return b
+}
+
+//WithSourceIP sets the ip address of the caller
+func (b *V2Request) WithSourceIP(ip string) *V2Request {
+	b.req.RequestContext.HTTP.SourceIP = ip
+	return b
+}
+
+//WithBody sets a text body
+func (b *V2Request) WithBody(body string) *V2Request {
+	b.req.Body, b.req.IsBase64Encoded = body, false
+	return b
+}
+
+//WithJSONBody sets the body to v encoded as json, and the Content-Type to application/json
+func (b *V2Request) WithJSONBody(v interface{}) *V2Request {
+	b.req.Headers["content-type"] = "application/json"
+	return b.WithBody(mustJSON(v))
+}
+
+//WithBase64Body sets a binary body, base64 encoded as apigateway does for binary media types
+func (b *V2Request) WithBase64Body(body []byte) *V2Request {
+	b.req.Body, b.req.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	return b
+}
+
+//Build returns the event
+func (b *V2Request) Build() events.APIGatewayV2HTTPRequest {
+	req := b.req
+	if len(b.query) > 0 {
+		req.RawQueryString = b.query.Encode()
+		req.QueryStringParameters = make(map[string]string, len(b.query))
+		for key, values := range b.query {
+			req.QueryStringParameters[key] = strings.Join(values, ",")
+		}
+	}
+	return req
+}
+
+//JSON returns the event as it would be sent to a lambda handler
+func (b *V2Request) JSON() json.RawMessage {
+	return json.RawMessage(mustJSON(b.Build()))
+}
+
+//ALBRequest builds an application load balancer target group event
+type ALBRequest struct {
+	req        events.ALBTargetGroupRequest
+	multiValue bool
+	query      [][2]string
+	header     http.Header
+}
+
+//NewALBRequest starts building a load balancer event for method and path
+func NewALBRequest(method, path string) *ALBRequest {
+	return &ALBRequest{
+		req: events.ALBTargetGroupRequest{
+			HTTPMethod: method,
+			Path:       path,
+			RequestContext: events.ALBTargetGroupRequestContext{
+				ELB: events.ELBContext{TargetGroupArn: "arn:aws:elasticloadbalancing:us-east-1:" + testAccountID + ":targetgroup/apigtest/0000000000000000"},
+			},
+		},
+		header: http.Header{"Host": {testHost}, "X-Forwarded-For": {testSourceIP}, "X-Forwarded-Proto": {"https"}},
+	}
+}
+
+//WithMultiValueHeaders sends headers and query parameters as multiValueHeaders and multiValueQueryStringParameters, as target groups with multi value headers enabled do
+func (b *ALBRequest) WithMultiValueHeaders() *ALBRequest {
+	b.multiValue = true
+	return b
+}
+
+//WithQuery adds query parameter values, they are escaped as they would be in the url as load balancers do not decode them
+func (b *ALBRequest) WithQuery(key string, values ...string) *ALBRequest {
+	for _, v := range values {
+		b.query = append(b.query, [2]string{url.QueryEscape(key), url.QueryEscape(v)})
+	}
+	return b
+}
+
+//WithHeader adds header values
+func (b *ALBRequest) WithHeader(key string, values ...string) *ALBRequest {
+	for _, v := range values {
+		b.header.Add(key, v)
+	}
+	return b
+}
+
+//WithHost sets the Host header, which defaults to apigtest.example.com
+func (b *ALBRequest) WithHost(host string) *ALBRequest {
+	b.header.Set("Host", host)
+	return b
+}
+
+//WithBody sets a text body
+func (b *ALBRequest) WithBody(body string) *ALBRequest {
+	b.req.Body, b.req.IsBase64Encoded = body, false
+	return b
+}
+
+//WithJSONBody sets the body to v encoded as json, and the Content-Type to application/json
+func (b *ALBRequest) WithJSONBody(v interface{}) *ALBRequest {
+	b.header.Set("Content-Type", "application/json")
+	return b.WithBody(mustJSON(v))
+}
+
+//WithBase64Body sets a binary body, base64 encoded
+func (b *ALBRequest) WithBase64Body(body []byte) *ALBRequest {
+	b.req.Body, b.req.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	return b
+}
+
+//Build returns the event
+func (b *ALBRequest) Build() events.ALBTargetGroupRequest {
+	req := b.req
+	if b.multiValue {
+		req.MultiValueHeaders = make(map[string][]string, len(b.header))
+		for key, values := range b.header {
+			req.MultiValueHeaders[strings.ToLower(key)] = values
+		}
+		req.MultiValueQueryStringParameters = make(map[string][]string, len(b.query))
+		for _, kv := range b.query {
+			req.MultiValueQueryStringParameters[kv[0]] = append(req.MultiValueQueryStringParameters[kv[0]], kv[1])
+		}
+		return req
+	}
+	//without multi value headers the load balancer only sends the last value
+	req.Headers = make(map[string]string, len(b.header))
+	for key, values := range b.header {
+		req.Headers[strings.ToLower(key)] = values[len(values)-1]
+	}
+	req.QueryStringParameters = make(map[string]string, len(b.query))
+	for _, kv := range b.query {
+		req.QueryStringParameters[kv[0]] = kv[1]
+	}
+	return req
+}
+
+//JSON returns the event as it would be sent to a lambda handler
+func (b *ALBRequest) JSON() json.RawMessage {
+	return json.RawMessage(mustJSON(b.Build()))
+}
+
+//WebSocketRequest builds a websocket api event
+type WebSocketRequest struct {
+	req events.APIGatewayWebsocketProxyRequest
+}
+
+//NewWebSocketRequest starts building a websocket event for routeKey from connectionID
+//The event type is CONNECT for $connect, DISCONNECT for $disconnect and MESSAGE otherwise
+func NewWebSocketRequest(routeKey, connectionID string) *WebSocketRequest {
+	now := time.Now()
+	eventType := "MESSAGE"
+	switch routeKey {
+	case "$connect":
+		eventType = "CONNECT"
+	case "$disconnect":
+		eventType = "DISCONNECT"
+	}
+	b := &WebSocketRequest{req: events.APIGatewayWebsocketProxyRequest{
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			AccountID:         testAccountID,
+			APIID:             testAPIID,
+			Stage:             "test",
+			RequestID:         testRequestID,
+			ExtendedRequestID: testRequestID,
+			ConnectionID:      connectionID,
+			ConnectedAt:       now.UnixNano() / int64(time.Millisecond),
+			DomainName:        testHost,
+			EventType:         eventType,
+			MessageDirection:  "IN",
+			RouteKey:          routeKey,
+			RequestTime:       now.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+			RequestTimeEpoch:  now.UnixNano() / int64(time.Millisecond),
+			Identity:          events.APIGatewayRequestIdentity{SourceIP: testSourceIP},
+		},
+	}}
+	if eventType == "CONNECT" {
+		//only the connect event has the headers of the upgrade request
+		b.req.Headers = map[string]string{"Host": testHost}
+		b.req.MultiValueHeaders = map[string][]string{"Host": {testHost}}
+	}
+	return b
+}
+
+//WithQuery adds query parameter values, which are only sent with the connect event
+func (b *WebSocketRequest) WithQuery(key string, values ...string) *WebSocketRequest {
+	if b.req.MultiValueQueryStringParameters == nil {
+		b.req.QueryStringParameters = map[string]string{}
+		b.req.MultiValueQueryStringParameters = map[string][]string{}
+	}
+	b.req.MultiValueQueryStringParameters[key] = append(b.req.MultiValueQueryStringParameters[key], values...)
+	if all := b.req.MultiValueQueryStringParameters[key]; len(all) > 0 {
+		b.req.QueryStringParameters[key] = all[len(all)-1]
+	}
+	return b
+}
+
+//WithHeader adds header values, which are only sent with the connect event
+func (b *WebSocketRequest) WithHeader(key string, values ...string) *WebSocketRequest {
+	if b.req.Headers == nil {
+		b.req.Headers = map[string]string{}
+		b.req.MultiValueHeaders = map[string][]string{}
+	}
+	b.req.MultiValueHeaders[key] = append(b.req.MultiValueHeaders[key], values...)
+	if all := b.req.MultiValueHeaders[key]; len(all) > 0 {
+		b.req.Headers[key] = all[len(all)-1]
+	}
+	return b
+}
+
+//WithAuthorizer sets the context returned by a lambda authorizer on connect
+func (b *WebSocketRequest) WithAuthorizer(authorizer map[string]interface{}) *WebSocketRequest {
+	b.req.RequestContext.Authorizer = authorizer
+	return b
+}
+
+//WithStage sets the stage the event was sent from
+func (b *WebSocketRequest) WithStage(stage string) *WebSocketRequest {
+	b.req.RequestContext.Stage = stage
+	return b
+}
+
+//WithBody sets a text message
+func (b *WebSocketRequest) WithBody(body string) *WebSocketRequest {
+	b.req.Body, b.req.IsBase64Encoded = body, false
+	return b
+}
+
+//WithJSONBody sets the message to v encoded as json
+func (b *WebSocketRequest) WithJSONBody(v interface{}) *WebSocketRequest {
+	return b.WithBody(mustJSON(v))
+}
+
+//WithBase64Body sets a binary message, base64 encoded as apigateway does
+func (b *WebSocketRequest) WithBase64Body(body []byte) *WebSocketRequest {
+	b.req.Body, b.req.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	return b
+}
+
+//Build returns the event
+func (b *WebSocketRequest) Build() events.APIGatewayWebsocketProxyRequest {
+	return b.req
+}
+
+//JSON returns the event as it would be sent to a lambda handler
+func (b *WebSocketRequest) JSON() json.RawMessage {
+	return json.RawMessage(mustJSON(b.req))
+}
+
+func joinCookie(header, name, value string) string {
+	cookie := (&http.Cookie{Name: name, Value: value}).String()
+	if header == "" {
+		return cookie
+	}
+	return header + "; " + cookie
+}
+
+//mustJSON encodes v, the builders only encode values that can always be encoded
+func mustJSON(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("apigtest: unable to encode %T as json: %v", v, err))
+	}
+	return string(raw)
+}
@@ -0,0 +1,124 @@
+package apigtest_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		echo := map[string]interface{}{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"query":  r.URL.Query(),
+			"accept": r.Header["Accept"],
+			"body":   string(body),
+		}
+		if c, err := r.Cookie("session"); err == nil {
+			echo["session"] = c.Value
+		}
+		if reqCtx, ok := apig.RequestContextFrom(r); ok {
+			echo["claims"] = reqCtx.Authorizer["claims"]
+		}
+		if reqCtx, ok := apig.V2RequestContextFrom(r); ok && reqCtx.Authorizer != nil {
+			echo["claims"] = reqCtx.Authorizer.JWT.Claims
+		}
+		http.SetCookie(rw, &http.Cookie{Name: "seen", Value: "yes"})
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(rw).Encode(echo)
+	})
+}
+
+func TestServeV1Request(t *testing.T) {
+	req := apigtest.NewV1Request("POST", "/users/a b").
+		WithQuery("tag", "x", "y&z").
+		WithHeader("Accept", "text/html", "application/json").
+		WithCookie("session", "abc").
+		WithAuthorizerClaims(map[string]interface{}{"sub": "user-1"}).
+		WithBase64Body([]byte("binary\x00body"))
+	event := req.Build()
+	require.Equal(t, "y&z", event.QueryStringParameters["tag"])
+	require.True(t, event.IsBase64Encoded)
+
+	apigtest.Serve(t, echoHandler(t), req).
+		AssertStatus(http.StatusAccepted).
+		AssertHeader("Content-Type", "application/json").
+		AssertCookie("seen", "yes").
+		AssertJSON(map[string]interface{}{
+			"method":  "POST",
+			"path":    "/users/a b",
+			"query":   map[string][]string{"tag": {"x", "y&z"}},
+			"accept":  []string{"text/html", "application/json"},
+			"body":    "binary\x00body",
+			"session": "abc",
+			"claims":  map[string]interface{}{"sub": "user-1"},
+		})
+}
+
+func TestServeV2Request(t *testing.T) {
+	req := apigtest.NewV2Request("PUT", "/users/a%20b").
+		WithQuery("tag", "x", "y&z").
+		WithHeader("Accept", "text/html", "application/json").
+		WithCookie("session", "abc").
+		WithAuthorizerClaims(map[string]string{"sub": "user-1"}).
+		WithJSONBody(map[string]string{"name": "zoë"})
+	require.Equal(t, "tag=x&tag=y%26z", req.Build().RawQueryString)
+
+	var echo map[string]interface{}
+	apigtest.ServeV2(t, echoHandler(t), req).
+		AssertStatus(http.StatusAccepted).
+		AssertCookie("seen", "yes").
+		DecodeJSON(&echo)
+	require.Equal(t, "/users/a b", echo["path"])
+	require.Equal(t, map[string]interface{}{"tag": []interface{}{"x", "y&z"}}, echo["query"])
+	//http apis join repeated headers with commas
+	require.Equal(t, []interface{}{"text/html,application/json"}, echo["accept"])
+	require.Equal(t, "abc", echo["session"])
+	require.Equal(t, map[string]interface{}{"sub": "user-1"}, echo["claims"])
+	require.Equal(t, `{"name":"zoë"}`, echo["body"])
+}
+
+func TestServeALBRequest(t *testing.T) {
+	for _, multiValue := range []bool{false, true} {
+		req := apigtest.NewALBRequest("GET", "/users").
+			WithQuery("tag", "x", "y&z").
+			WithHeader("Accept", "text/html", "application/json")
+		query := []interface{}{"y&z"}
+		accept := []interface{}{"application/json"}
+		if multiValue {
+			req = req.WithMultiValueHeaders()
+			query = []interface{}{"x", "y&z"}
+			accept = []interface{}{"text/html", "application/json"}
+		}
+		var echo map[string]interface{}
+		apigtest.ServeALB(t, echoHandler(t), req).
+			AssertStatus(http.StatusAccepted).
+			AssertCookie("seen", "yes").
+			DecodeJSON(&echo)
+		require.Equal(t, map[string]interface{}{"tag": query}, echo["query"])
+		require.Equal(t, accept, echo["accept"])
+	}
+}
+
+func TestWebSocketRequest(t *testing.T) {
+	var connect events.APIGatewayWebsocketProxyRequest
+	require.NoError(t, json.Unmarshal(apigtest.NewWebSocketRequest("$connect", "conn-1").WithQuery("token", "abc").JSON(), &connect))
+	require.Equal(t, "CONNECT", connect.RequestContext.EventType)
+	require.Equal(t, "conn-1", connect.RequestContext.ConnectionID)
+	require.Equal(t, "abc", connect.QueryStringParameters["token"])
+
+	message := apigtest.NewWebSocketRequest("sendMessage", "conn-1").WithJSONBody(map[string]string{"action": "sendMessage"}).Build()
+	require.Equal(t, "MESSAGE", message.RequestContext.EventType)
+	require.Equal(t, `{"action":"sendMessage"}`, message.Body)
+	require.Nil(t, message.Headers)
+}
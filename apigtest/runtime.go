@@ -0,0 +1,237 @@
+//Package apigtest has helpers for testing lambdas built with apig without deploying them
+package apigtest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const runtimePrefix = "/2018-06-01/runtime/"
+
+var ErrRuntimeClosed = errors.New("Runtime api has been closed")
+
+//RuntimeError is an error reported to the runtime api
+type RuntimeError struct {
+	ErrorMessage string   `json:"errorMessage"`
+	ErrorType    string   `json:"errorType"`
+	StackTrace   []string `json:"stackTrace,omitempty"`
+}
+
+func (e *RuntimeError) Error() string {
+	return e.ErrorType + ": " + e.ErrorMessage
+}
+
+//Result is what the runtime posted back for an invocation
+type Result struct {
+	//Body is the response body, for streamed responses this includes the status and headers prelude
+	Body []byte
+	//Streamed is set when the response was posted using the streaming response mode
+	Streamed bool
+	//Error is set when the invocation failed, or a streamed response reported an error in its trailers
+	Error *RuntimeError
+}
+
+type invocation struct {
+	id       string
+	event    []byte
+	deadline time.Time
+	result   chan Result
+}
+
+//RuntimeAPI is an in-process fake of the lambda runtime api, so a runtime loop can be tested end to end without aws
+//Point AWS_LAMBDA_RUNTIME_API, or apig.NewRuntimeClient, at Address and call Invoke to send events
+type RuntimeAPI struct {
+	//Timeout is the invocation deadline sent to the runtime, it defaults to 30 seconds
+	Timeout time.Duration
+	//FunctionArn is sent as the invoked function arn
+	FunctionArn string
+
+	server  *httptest.Server
+	queue   chan *invocation
+	closed  chan struct{}
+	mu      sync.Mutex
+	pending map[string]*invocation
+	nextID  int
+	initErr *RuntimeError
+}
+
+//NewRuntimeAPI starts a fake runtime api, it should be closed once the test is finished
+func NewRuntimeAPI() *RuntimeAPI {
+	api := &RuntimeAPI{
+		Timeout:     30 * time.Second,
+		FunctionArn: "arn:aws:lambda:us-east-1:123456789012:function:apigtest",
+		queue:       make(chan *invocation),
+		closed:      make(chan struct{}),
+		pending:     make(map[string]*invocation),
+	}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serveHTTP))
+	return api
+}
+
+//Address is the host:port of the runtime api, as it would be set in AWS_LAMBDA_RUNTIME_API
+func (api *RuntimeAPI) Address() string {
+	return strings.TrimPrefix(api.server.URL, "http://")
+}
+
+//Close stops the runtime api, runtimes waiting for an invocation will get an error
+func (api *RuntimeAPI) Close() {
+	close(api.closed)
+	api.server.CloseClientConnections()
+	api.server.Close()
+}
+
+//InitError returns the error the runtime reported while initialising, if any
+func (api *RuntimeAPI) InitError() *RuntimeError {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.initErr
+}
+
+//Invoke sends an event to the runtime and waits for its result
+func (api *RuntimeAPI) Invoke(ctx context.Context, event []byte) (Result, error) {
+	api.mu.Lock()
+	api.nextID++
+	inv := &invocation{
+		id:       fmt.Sprintf("apigtest-%d", api.nextID),
+		event:    event,
+		deadline: time.Now().Add(api.Timeout),
+		result:   make(chan Result, 1),
+	}
+	api.pending[inv.id] = inv
+	api.mu.Unlock()
+
+	select {
+	case api.queue <- inv:
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	case <-api.closed:
+		return Result{}, ErrRuntimeClosed
+	}
+	select {
+	case result := <-inv.result:
+		return result, nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	case <-api.closed:
+		return Result{}, ErrRuntimeClosed
+	}
+}
+
+//Handler returns a lambda handler func that invokes the runtime, so a handler binary can be used with apig.LocalHandler or apig.Replay
+//Invocation errors are returned as *RuntimeError
+func (api *RuntimeAPI) Handler() func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	return func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		result, err := api.Invoke(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		return json.RawMessage(result.Body), nil
+	}
+}
+
+func (api *RuntimeAPI) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, runtimePrefix)
+	switch {
+	case r.Method == http.MethodGet && path == "invocation/next":
+		api.next(rw, r)
+	case r.Method == http.MethodPost && path == "init/error":
+		rErr, err := decodeRuntimeError(r)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		api.mu.Lock()
+		api.initErr = rErr
+		api.mu.Unlock()
+		rw.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "invocation/"):
+		parts := strings.Split(strings.TrimPrefix(path, "invocation/"), "/")
+		if len(parts) != 2 || (parts[1] != "response" && parts[1] != "error") {
+			http.NotFound(rw, r)
+			return
+		}
+		api.mu.Lock()
+		inv, ok := api.pending[parts[0]]
+		delete(api.pending, parts[0])
+		api.mu.Unlock()
+		if !ok {
+			http.Error(rw, "Unknown request id "+parts[0], http.StatusBadRequest)
+			return
+		}
+		result, err := readResult(r, parts[1] == "error")
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		inv.result <- result
+		rw.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+func (api *RuntimeAPI) next(rw http.ResponseWriter, r *http.Request) {
+	select {
+	case inv := <-api.queue:
+		rw.Header().Set("Lambda-Runtime-Aws-Request-Id", inv.id)
+		rw.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(inv.deadline.UnixNano()/int64(time.Millisecond), 10))
+		rw.Header().Set("Lambda-Runtime-Invoked-Function-Arn", api.FunctionArn)
+		rw.Header().Set("Lambda-Runtime-Trace-Id", "Root=1-00000000-000000000000000000000000;Parent=0000000000000000;Sampled=0")
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write(inv.event)
+	case <-r.Context().Done():
+	case <-api.closed:
+		http.Error(rw, ErrRuntimeClosed.Error(), http.StatusInternalServerError)
+	}
+}
+
+func readResult(r *http.Request, isError bool) (Result, error) {
+	if isError {
+		rErr, err := decodeRuntimeError(r)
+		return Result{Error: rErr}, err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Body:     body,
+		Streamed: r.Header.Get("Lambda-Runtime-Function-Response-Mode") == "streaming",
+	}
+	//trailers are only available once the body has been read
+	if errBody := r.Trailer.Get("Lambda-Runtime-Function-Error-Body"); errBody != "" {
+		raw, err := base64.StdEncoding.DecodeString(errBody)
+		if err != nil {
+			return result, err
+		}
+		result.Error = &RuntimeError{}
+		if err = json.Unmarshal(raw, result.Error); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func decodeRuntimeError(r *http.Request) (*RuntimeError, error) {
+	rErr := &RuntimeError{}
+	if err := json.NewDecoder(r.Body).Decode(rErr); err != nil {
+		return nil, err
+	}
+	if rErr.ErrorType == "" {
+		rErr.ErrorType = r.Header.Get("Lambda-Runtime-Function-Error-Type")
+	}
+	return rErr, nil
+}
@@ -0,0 +1,183 @@
+package apigtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+)
+
+//Response is the response a handler produced for a built event, with the assertions tests usually make on it
+//Assertions report failures with t.Errorf and return the response so they can be chained
+type Response struct {
+	t          testing.TB
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+//Serve runs handler with the event through apig.Serve
+func Serve(t testing.TB, handler http.Handler, req *V1Request) *Response {
+	t.Helper()
+	resp, err := apig.Serve(req.Build(), handler)
+	if err != nil {
+		t.Fatalf("apig.Serve returned an error: %v", err)
+	}
+	header := http.Header{}
+	for key, value := range resp.Headers {
+		header.Set(key, value)
+	}
+	for key, values := range resp.MultiValueHeaders {
+		header[http.CanonicalHeaderKey(key)] = values
+	}
+	return newResponse(t, resp.StatusCode, header, resp.Body, resp.IsBase64Encoded)
+}
+
+//ServeV2 runs handler with the event through apig.ServeV2
+func ServeV2(t testing.TB, handler http.Handler, req *V2Request) *Response {
+	t.Helper()
+	resp, err := apig.ServeV2(req.Build(), handler)
+	if err != nil {
+		t.Fatalf("apig.ServeV2 returned an error: %v", err)
+	}
+	header := http.Header{}
+	for key, value := range resp.Headers {
+		header.Set(key, value)
+	}
+	for _, cookie := range resp.Cookies {
+		header.Add("Set-Cookie", cookie)
+	}
+	return newResponse(t, resp.StatusCode, header, resp.Body, resp.IsBase64Encoded)
+}
+
+//ServeALB runs handler with the event through apig.ServeALB
+func ServeALB(t testing.TB, handler http.Handler, req *ALBRequest) *Response {
+	t.Helper()
+	resp, err := apig.ServeALB(req.Build(), handler)
+	if err != nil {
+		t.Fatalf("apig.ServeALB returned an error: %v", err)
+	}
+	header := http.Header{}
+	for key, value := range resp.Headers {
+		header.Set(key, value)
+	}
+	for key, values := range resp.MultiValueHeaders {
+		header[http.CanonicalHeaderKey(key)] = values
+	}
+	return newResponse(t, resp.StatusCode, header, resp.Body, resp.IsBase64Encoded)
+}
+
+func newResponse(t testing.TB, status int, header http.Header, body string, isBase64Encoded bool) *Response {
+	t.Helper()
+	r := &Response{t: t, StatusCode: status, Header: header, Body: []byte(body)}
+	if isBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			t.Fatalf("Response body is not valid base64: %v", err)
+		}
+		r.Body = decoded
+	}
+	return r
+}
+
+//Cookies returns the cookies set by the response
+func (r *Response) Cookies() []*http.Cookie {
+	return (&http.Response{Header: r.Header}).Cookies()
+}
+
+//Cookie returns the cookie set by the response with name, or nil if it wasn't set
+func (r *Response) Cookie(name string) *http.Cookie {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+//AssertStatus checks the status code
+func (r *Response) AssertStatus(status int) *Response {
+	r.t.Helper()
+	if r.StatusCode != status {
+		r.t.Errorf("Expected status %d but got %d with body %s", status, r.StatusCode, r.Body)
+	}
+	return r
+}
+
+//AssertHeader checks the first value of a header
+func (r *Response) AssertHeader(key, value string) *Response {
+	r.t.Helper()
+	if got := r.Header.Get(key); got != value {
+		r.t.Errorf("Expected header %s to be %q but got %q", key, value, got)
+	}
+	return r
+}
+
+//AssertNoHeader checks a header was not set
+func (r *Response) AssertNoHeader(key string) *Response {
+	r.t.Helper()
+	if got, ok := r.Header[http.CanonicalHeaderKey(key)]; ok {
+		r.t.Errorf("Expected header %s not to be set but got %q", key, got)
+	}
+	return r
+}
+
+//AssertCookie checks a cookie was set with value
+func (r *Response) AssertCookie(name, value string) *Response {
+	r.t.Helper()
+	c := r.Cookie(name)
+	if c == nil {
+		r.t.Errorf("Expected cookie %s to be set, got %q", name, r.Header["Set-Cookie"])
+	} else if c.Value != value {
+		r.t.Errorf("Expected cookie %s to be %q but got %q", name, value, c.Value)
+	}
+	return r
+}
+
+//AssertBody checks the body is exactly body
+func (r *Response) AssertBody(body string) *Response {
+	r.t.Helper()
+	if string(r.Body) != body {
+		r.t.Errorf("Expected body %q but got %q", body, r.Body)
+	}
+	return r
+}
+
+//AssertJSON checks the body is json equal to expected, ignoring formatting and key order
+//expected can be a json string or any value that encodes to json
+func (r *Response) AssertJSON(expected interface{}) *Response {
+	r.t.Helper()
+	var expectedRaw []byte
+	switch e := expected.(type) {
+	case string:
+		expectedRaw = []byte(e)
+	case []byte:
+		expectedRaw = e
+	default:
+		expectedRaw = []byte(mustJSON(e))
+	}
+	var want, got interface{}
+	if err := json.Unmarshal(expectedRaw, &want); err != nil {
+		r.t.Fatalf("Expected json is not valid: %v", err)
+	}
+	if err := json.Unmarshal(r.Body, &got); err != nil {
+		r.t.Errorf("Expected a json body but got %q: %v", r.Body, err)
+		return r
+	}
+	if !reflect.DeepEqual(want, got) {
+		r.t.Errorf("Expected json body %s but got %s", expectedRaw, r.Body)
+	}
+	return r
+}
+
+//DecodeJSON decodes the body into v, failing the test if it isn't valid json
+func (r *Response) DecodeJSON(v interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		r.t.Fatalf("Unable to decode body %q: %v", r.Body, err)
+	}
+	return r
+}
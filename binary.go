@@ -0,0 +1,61 @@
+package apig
+
+import (
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+//binaryMediaTypes mirrors the binaryMediaTypes setting on the api gateway, responses with a matching Content-Type are base64 encoded
+var binaryMediaTypes []string
+
+//SetBinaryMediaTypes sets the media types that will be base64 encoded in responses
+//Entries can be exact ("image/png"), a subtype wildcard ("image/*") or "*/*" to encode everything
+func SetBinaryMediaTypes(types ...string) {
+	binaryMediaTypes = make([]string, 0, len(types))
+	for _, t := range types {
+		binaryMediaTypes = append(binaryMediaTypes, strings.ToLower(strings.TrimSpace(t)))
+	}
+}
+
+func isBinaryMediaType(contentType string) bool {
+	if contentType == "" || len(binaryMediaTypes) == 0 {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	for _, t := range binaryMediaTypes {
+		if t == "*/*" || t == mediaType {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+//decodeBody returns the raw request body, decoding it if apigateway has base64 encoded it
+func decodeBody(body string, isBase64Encoded bool) ([]byte, error) {
+	if !isBase64Encoded {
+		return []byte(body), nil
+	}
+	return base64.StdEncoding.DecodeString(body)
+}
+
+//encodeBody formats the response body for apigateway, base64 encoding it if the Content-Type is a binary media type or it has a Content-Encoding such as gzip
+func encodeBody(header http.Header, body []byte) (string, bool) {
+	if isBinaryMediaType(header.Get("Content-Type")) || isContentEncoded(header) {
+		return base64.StdEncoding.EncodeToString(body), true
+	}
+	return string(body), false
+}
+
+//isContentEncoded reports whether the body has been compressed, making it binary whatever its Content-Type
+func isContentEncoded(header http.Header) bool {
+	encoding := strings.TrimSpace(header.Get("Content-Encoding"))
+	return encoding != "" && !strings.EqualFold(encoding, "identity")
+}
@@ -0,0 +1,84 @@
+package apig_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+var pngBytes = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0xff}
+
+func TestToStdLibRequestBase64Body(t *testing.T) {
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod:      http.MethodPost,
+		Path:            "/upload",
+		Body:            base64.StdEncoding.EncodeToString(pngBytes),
+		IsBase64Encoded: true,
+	}
+	stReq, err := apig.ToStdLibRequest(req)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(stReq.Body)
+	require.NoError(t, err)
+	require.Equal(t, pngBytes, b)
+
+	req.Body = "not base64!"
+	_, err = apig.ToStdLibRequest(req)
+	require.Error(t, err)
+}
+
+func TestToStdLibRequestV2Base64Body(t *testing.T) {
+	req := events.APIGatewayV2HTTPRequest{
+		RawPath:         "/upload",
+		Body:            base64.StdEncoding.EncodeToString(pngBytes),
+		IsBase64Encoded: true,
+	}
+	req.RequestContext.HTTP.Method = http.MethodPost
+	stReq, err := apig.ToStdLibRequestV2(req)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(stReq.Body)
+	require.NoError(t, err)
+	require.Equal(t, pngBytes, b)
+}
+
+func TestServeBinaryMediaTypes(t *testing.T) {
+	apig.SetBinaryMediaTypes("image/*", "application/x-protobuf")
+	defer apig.SetBinaryMediaTypes()
+
+	tests := []struct {
+		contentType string
+		binary      bool
+	}{
+		{"image/png", true},
+		{"application/x-protobuf; charset=binary", true},
+		{"application/json", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if tt.contentType != "" {
+				rw.Header().Set("Content-Type", tt.contentType)
+			}
+			rw.Write(pngBytes)
+		})
+
+		resp, err := apig.Serve(events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/image"}, handler)
+		require.NoError(t, err)
+		require.Equal(t, tt.binary, resp.IsBase64Encoded, tt.contentType)
+		if tt.binary {
+			require.Equal(t, base64.StdEncoding.EncodeToString(pngBytes), resp.Body)
+		} else {
+			require.Equal(t, string(pngBytes), resp.Body)
+		}
+
+		v2req := events.APIGatewayV2HTTPRequest{RawPath: "/image"}
+		v2req.RequestContext.HTTP.Method = http.MethodGet
+		v2resp, err := apig.ServeV2(v2req, handler)
+		require.NoError(t, err)
+		require.Equal(t, tt.binary, v2resp.IsBase64Encoded, tt.contentType)
+	}
+}
@@ -0,0 +1,105 @@
+//apig-local runs a lambda handler binary locally behind an emulated apigateway
+//
+//	apig-local -addr :8080 -version 2.0 -stage-var env=local -claim sub=user-1 -- ./bin/handler
+//
+//The handler is started with AWS_LAMBDA_RUNTIME_API pointing at an in-process runtime api, so it should use
+//apig.StartRuntime or lambda.Start as it would when deployed. Each http request is converted to an event the way
+//apigateway would, sent to the handler, and its response converted back.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+)
+
+//keyValues collects repeated key=value flags
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	pairs := make([]string, 0, len(kv))
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (kv keyValues) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("%q is not in the form key=value", value)
+	}
+	kv[parts[0]] = parts[1]
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	stage := flag.String("stage", "", "stage name sent in the events")
+	stagePrefix := flag.Bool("stage-prefix", false, "expect request paths to start with /{stage}, like execute-api urls")
+	version := flag.String("version", "1.0", "payload format version, 1.0 or 2.0")
+	stageVariables := keyValues{}
+	flag.Var(stageVariables, "stage-var", "stage variable as key=value, can be repeated")
+	claims := keyValues{}
+	flag.Var(claims, "claim", "authorizer claim as key=value, can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] -- handler [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	runtimeAPI := apigtest.NewRuntimeAPI()
+	defer runtimeAPI.Close()
+
+	cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "AWS_LAMBDA_RUNTIME_API="+runtimeAPI.Address())
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		err := cmd.Wait()
+		if initErr := runtimeAPI.InitError(); initErr != nil {
+			log.Println("Handler failed to initialise: " + initErr.Error())
+		}
+		log.Fatalf("Handler exited: %v", err)
+	}()
+
+	opts := apig.LocalOptions{
+		Stage:          *stage,
+		StagePrefix:    *stagePrefix,
+		StageVariables: stageVariables,
+		PayloadVersion: *version,
+	}
+	if len(claims) > 0 {
+		opts.AuthorizerClaims = make(map[string]interface{}, len(claims))
+		for k, v := range claims {
+			opts.AuthorizerClaims[k] = v
+		}
+	}
+	server := &http.Server{Addr: *addr, Handler: apig.LocalHandler(runtimeAPI.Handler(), opts)}
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		server.Close()
+		cmd.Process.Kill()
+	}()
+	log.Printf("Serving %s on %s", flag.Arg(0), *addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,122 @@
+//apig-replay feeds events recorded with apig.Record through a lambda handler binary and reports responses that changed
+//
+//	apig-replay -recording requests.jsonl -ignore-header Date -restore-header "Authorization: Bearer test-token" -- ./bin/handler
+//
+//The handler is started with AWS_LAMBDA_RUNTIME_API pointing at an in-process runtime api, as it is by apig-local.
+//Events recorded with redacted headers are replayed with the values given by -restore-header, or skipped with -skip-redacted.
+//The command exits with status 1 when any response differs from the recorded one.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+)
+
+//headerNames collects repeated header name flags
+type headerNames []string
+
+func (h *headerNames) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *headerNames) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+func main() {
+	recordingPath := flag.String("recording", "requests.jsonl", "recording written by apig.Record")
+	var ignoreHeaders, restoreHeaders headerNames
+	flag.Var(&ignoreHeaders, "ignore-header", "header that is expected to differ between runs, can be repeated")
+	flag.Var(&restoreHeaders, "restore-header", `"Name: value" to replay in place of a redacted header, can be repeated`)
+	skipRedacted := flag.Bool("skip-redacted", false, "skip events recorded with redacted inputs instead of replaying them")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] -- handler [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := apig.ReplayOptions{IgnoreHeaders: ignoreHeaders, SkipRedacted: *skipRedacted}
+	if len(restoreHeaders) > 0 {
+		values := map[string]string{}
+		for _, h := range restoreHeaders {
+			parts := strings.SplitN(h, ":", 2)
+			if len(parts) != 2 {
+				log.Fatalf("Invalid -restore-header %q, expected \"Name: value\"", h)
+			}
+			values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		opts.Restore = apig.RestoreHeaders(values)
+	}
+
+	recording, err := os.Open(*recordingPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer recording.Close()
+
+	runtimeAPI := apigtest.NewRuntimeAPI()
+	defer runtimeAPI.Close()
+
+	cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "AWS_LAMBDA_RUNTIME_API="+runtimeAPI.Address())
+	if err = cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+	finished := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		select {
+		case <-finished:
+			return
+		default:
+		}
+		if initErr := runtimeAPI.InitError(); initErr != nil {
+			log.Println("Handler failed to initialise: " + initErr.Error())
+		}
+		log.Fatalf("Handler exited: %v", err)
+	}()
+
+	results, err := apig.ReplayLambda(context.Background(), recording, runtimeAPI.Handler(), opts)
+	close(finished)
+	cmd.Process.Kill()
+	if err != nil {
+		log.Fatal(err)
+	}
+	changed, skipped := 0, 0
+	for _, result := range results {
+		if result.Skipped {
+			skipped++
+			continue
+		}
+		if len(result.Diffs) == 0 {
+			continue
+		}
+		changed++
+		fmt.Printf("%s:%d\n", *recordingPath, result.Line)
+		for _, diff := range result.Diffs {
+			fmt.Println("\t" + diff)
+		}
+	}
+	fmt.Printf("%d of %d responses changed\n", changed, len(results)-skipped)
+	if skipped > 0 {
+		fmt.Printf("%d events with redacted inputs were skipped\n", skipped)
+	}
+	if changed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,100 @@
+package apig
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type contextKey int
+
+const (
+	eventContextKey contextKey = iota
+	stageContextKey
+	routeContextKey
+)
+
+//withEvent attaches the event a request was converted from to the context
+func withEvent(ctx context.Context, event interface{}) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, eventContextKey, event)
+}
+
+//EventFrom returns the event the request was converted from
+//This will be an events.APIGatewayProxyRequest, events.APIGatewayV2HTTPRequest or events.ALBTargetGroupRequest, or nil if the request wasn't served by this package
+func EventFrom(r *http.Request) interface{} {
+	return r.Context().Value(eventContextKey)
+}
+
+//RequestContextFrom returns the apigateway request context (authorizer, identity, stage, api id etc) of a request served with Serve
+func RequestContextFrom(r *http.Request) (events.APIGatewayProxyRequestContext, bool) {
+	event, ok := EventFrom(r).(events.APIGatewayProxyRequest)
+	return event.RequestContext, ok
+}
+
+//V2RequestContextFrom returns the apigateway request context (authorizer, http details, stage, api id etc) of a request served with ServeV2
+func V2RequestContextFrom(r *http.Request) (events.APIGatewayV2HTTPRequestContext, bool) {
+	event, ok := EventFrom(r).(events.APIGatewayV2HTTPRequest)
+	return event.RequestContext, ok
+}
+
+//PathParameters returns the path parameters apigateway, or a Router, matched for the request's resource
+//The returned map should not be modified
+func PathParameters(r *http.Request) map[string]string {
+	if matched, ok := r.Context().Value(routeContextKey).(matchedRoute); ok {
+		return matched.params
+	}
+	switch event := EventFrom(r).(type) {
+	case events.APIGatewayProxyRequest:
+		return event.PathParameters
+	case events.APIGatewayV2HTTPRequest:
+		return event.PathParameters
+	}
+	return nil
+}
+
+//Stage returns the apigateway stage a request was sent to, or an empty string if it isn't known
+func Stage(r *http.Request) string {
+	if stage, ok := r.Context().Value(stageContextKey).(string); ok {
+		return stage
+	}
+	switch event := EventFrom(r).(type) {
+	case events.APIGatewayProxyRequest:
+		return event.RequestContext.Stage
+	case events.APIGatewayV2HTTPRequest:
+		return event.RequestContext.Stage
+	}
+	return ""
+}
+
+//StageVariable returns the value of the apigateway stage variable for the request, or an empty string if it isn't set
+func StageVariable(r *http.Request, name string) string {
+	switch event := EventFrom(r).(type) {
+	case events.APIGatewayProxyRequest:
+		return event.StageVariables[name]
+	case events.APIGatewayV2HTTPRequest:
+		return event.StageVariables[name]
+	}
+	return ""
+}
+
+var stageVariablesInEnv bool
+
+//SetStageVariablesInEnv enables copying each request's stage variables into the process environment, as was done before StageVariable existed
+//This is not safe when stages share a container or the environment is read concurrently, prefer StageVariable
+func SetStageVariablesInEnv(enabled bool) {
+	stageVariablesInEnv = enabled
+}
+
+func exportStageVariables(vars map[string]string) {
+	if !stageVariablesInEnv {
+		return
+	}
+	for k, v := range vars {
+		os.Setenv(k, v)
+	}
+}
@@ -0,0 +1,100 @@
+package apig_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeWithContextPropagatesLambdaContext(t *testing.T) {
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	ctx = lambdacontext.NewContext(ctx, &lambdacontext.LambdaContext{AwsRequestID: "aws-request-id"})
+
+	req := events.APIGatewayProxyRequest{}
+	require.NoError(t, json.Unmarshal([]byte(testRequest), &req))
+
+	called := false
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+		d, ok := r.Context().Deadline()
+		require.True(t, ok)
+		require.Equal(t, deadline, d)
+
+		lc, ok := lambdacontext.FromContext(r.Context())
+		require.True(t, ok)
+		require.Equal(t, "aws-request-id", lc.AwsRequestID)
+
+		reqCtx, ok := apig.RequestContextFrom(r)
+		require.True(t, ok)
+		require.Equal(t, "c6af9ac6-7b61-11e6-9a41-93e8deadbeef", reqCtx.RequestID)
+		require.Equal(t, "1234567890", reqCtx.APIID)
+		require.Equal(t, "127.0.0.1", reqCtx.Identity.SourceIP)
+
+		_, ok = apig.V2RequestContextFrom(r)
+		require.False(t, ok)
+
+		require.Equal(t, map[string]string{"proxy": "path/to/resource"}, apig.PathParameters(r))
+		require.IsType(t, events.APIGatewayProxyRequest{}, apig.EventFrom(r))
+	})
+
+	_, err := apig.ServeWithContext(ctx, req, handler)
+	require.NoError(t, err)
+	require.True(t, called)
+}
+
+func TestLambdaHandlerWithContextV2RequestContext(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "aws-request-id"})
+
+	called := false
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+		lc, ok := lambdacontext.FromContext(r.Context())
+		require.True(t, ok)
+		require.Equal(t, "aws-request-id", lc.AwsRequestID)
+
+		reqCtx, ok := apig.V2RequestContextFrom(r)
+		require.True(t, ok)
+		require.Equal(t, "abcdefg", reqCtx.APIID)
+		require.Equal(t, "123.123.123.123", reqCtx.HTTP.SourceIP)
+	})
+	_, err := apig.LambdaHandlerWithContext(handler, nil)(ctx, json.RawMessage(testFunctionURLRequest))
+	require.NoError(t, err)
+	require.True(t, called)
+
+	fallback := func(fctx context.Context, event json.RawMessage) (interface{}, error) {
+		require.Equal(t, ctx, fctx)
+		return nil, nil
+	}
+	_, err = apig.LambdaHandlerWithContext(handler, fallback)(ctx, json.RawMessage(`{}`))
+	require.NoError(t, err)
+}
+
+func TestStageVariable(t *testing.T) {
+	os.Unsetenv("baz")
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(apig.StageVariable(r, "baz") + apig.StageVariable(r, "missing")))
+	})
+
+	resp, err := apig.LambdaHandler(handler, nil)(json.RawMessage(testRequest))
+	require.NoError(t, err)
+	require.Equal(t, "qux", resp.(events.APIGatewayProxyResponse).Body)
+	_, set := os.LookupEnv("baz")
+	require.False(t, set)
+
+	apig.SetStageVariablesInEnv(true)
+	defer apig.SetStageVariablesInEnv(false)
+	defer os.Unsetenv("baz")
+	_, err = apig.LambdaHandler(handler, nil)(json.RawMessage(testRequest))
+	require.NoError(t, err)
+	require.Equal(t, "qux", os.Getenv("baz"))
+}
@@ -0,0 +1,166 @@
+package apig
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//CORSConfig is the cross origin resource sharing policy applied by Respond, RespondV2 and, once SetCORSConfig is called, the Serve functions
+type CORSConfig struct {
+	//AllowedOrigins can contain exact origins ("https://spalk.tv"), wildcard subdomains ("https://*.spalk.tv") or "*" for any origin
+	AllowedOrigins []string
+	//AllowOriginFunc is consulted for origins that don't match AllowedOrigins
+	AllowOriginFunc  func(origin string) bool
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	//MaxAge is how long browsers may cache a preflight response, it is not sent when zero
+	MaxAge time.Duration
+	//Routes replaces the policy for requests whose path is the key or below it, the longest matching prefix wins
+	Routes map[string]CORSConfig
+}
+
+//DefaultCORSConfig returns the policy Respond and RespondV2 use unless SetCORSConfig is called, it allows any origin
+func DefaultCORSConfig() *CORSConfig {
+	return &CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "X-Amz-Date", "X-Api-Key", "X-Amz-Security-Token"},
+	}
+}
+
+var corsConfig = DefaultCORSConfig()
+
+//serveCORSConfig is the policy applied by the Serve functions, they don't send CORS headers or answer preflights until SetCORSConfig is called
+var serveCORSConfig *CORSConfig
+
+//SetCORSConfig sets the policy used for all responses, including those of Serve, ServeV2, ServeALB and ServeStream
+//nil disables CORS headers and preflight handling
+func SetCORSConfig(cfg *CORSConfig) {
+	corsConfig = cfg
+	serveCORSConfig = cfg
+}
+
+//forPath returns the policy that applies to a request path
+func (c *CORSConfig) forPath(path string) *CORSConfig {
+	if c == nil {
+		return nil
+	}
+	match := ""
+	for prefix := range c.Routes {
+		if pathHasPrefix(path, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return c
+	}
+	route := c.Routes[match]
+	return &route
+}
+
+//pathHasPrefix reports whether prefix is made up of whole segments at the start of path, so /public matches /public/a but not /publicity
+func pathHasPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+//allowOrigin returns the value for the Access-Control-Allow-Origin header, or false if the origin isn't allowed
+func (c *CORSConfig) allowOrigin(origin string) (string, bool) {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			//the wildcard can't be used with credentials, so the origin is echoed instead
+			if c.AllowCredentials && origin != "" {
+				return origin, true
+			}
+			return "*", true
+		}
+		if origin == "" {
+			continue
+		}
+		if allowed == origin {
+			return origin, true
+		}
+		if i := strings.Index(allowed, "*"); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return origin, true
+			}
+		}
+	}
+	if origin != "" && c.AllowOriginFunc != nil && c.AllowOriginFunc(origin) {
+		return origin, true
+	}
+	return "", false
+}
+
+//headers returns the CORS headers for a request from origin
+func (c *CORSConfig) headers(origin string, preflight bool) map[string]string {
+	headers := map[string]string{}
+	if c == nil {
+		return headers
+	}
+	allowOrigin, ok := c.allowOrigin(origin)
+	if !ok {
+		return headers
+	}
+	headers["Access-Control-Allow-Origin"] = allowOrigin
+	if allowOrigin != "*" {
+		headers["Vary"] = "Origin"
+	}
+	if len(c.AllowedMethods) > 0 {
+		headers["Access-Control-Allow-Methods"] = strings.Join(c.AllowedMethods, ",")
+	}
+	if len(c.AllowedHeaders) > 0 {
+		headers["Access-Control-Allow-Headers"] = strings.Join(c.AllowedHeaders, ",")
+	}
+	if len(c.ExposedHeaders) > 0 && !preflight {
+		headers["Access-Control-Expose-Headers"] = strings.Join(c.ExposedHeaders, ",")
+	}
+	if c.AllowCredentials {
+		headers["Access-Control-Allow-Credentials"] = "true"
+	}
+	if c.MaxAge > 0 && preflight {
+		headers["Access-Control-Max-Age"] = strconv.Itoa(int(c.MaxAge.Seconds()))
+	}
+	return headers
+}
+
+//isPreflight reports whether the request is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+//withCORS adds the CORS headers to responses and answers preflight requests without calling the handler
+func withCORS(handler http.Handler) http.Handler {
+	cfg := serveCORSConfig
+	if cfg == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		preflight := isPreflight(r)
+		for key, value := range cfg.forPath(r.URL.Path).headers(r.Header.Get("Origin"), preflight) {
+			rw.Header().Set(key, value)
+		}
+		if preflight {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(rw, r)
+	})
+}
+
+//headerValue looks up a header in an event's header map, which may not be canonically cased
+func headerValue(headers map[string]string, key string) string {
+	if value, ok := headers[key]; ok {
+		return value
+	}
+	for k, value := range headers {
+		if strings.EqualFold(k, key) {
+			return value
+		}
+	}
+	return ""
+}
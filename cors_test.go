@@ -0,0 +1,130 @@
+package apig_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRespondDefaultCORS(t *testing.T) {
+	resp, err := apig.Respond(map[string]string{"a": "b"}, http.StatusOK, events.APIGatewayProxyRequest{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "DELETE,GET,HEAD,OPTIONS,PATCH,POST,PUT",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,X-Amz-Date,X-Api-Key,X-Amz-Security-Token",
+		"Content-Type":                 "application/json",
+	}, resp.Headers)
+}
+
+func TestServeNoCORSByDefault(t *testing.T) {
+	called := false
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+		rw.WriteHeader(http.StatusOK)
+	})
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: http.MethodOptions,
+		Path:       "/users",
+		Headers: map[string]string{
+			"Origin":                        "https://spalk.tv",
+			"Access-Control-Request-Method": "PUT",
+		},
+	}
+	resp, err := apig.Serve(req, handler)
+	require.NoError(t, err)
+	require.True(t, called)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.MultiValueHeaders["Access-Control-Allow-Origin"])
+}
+
+func TestCORSConfigOrigins(t *testing.T) {
+	apig.SetCORSConfig(&apig.CORSConfig{
+		AllowedOrigins:   []string{"https://spalk.tv", "https://*.spalk.tv"},
+		AllowOriginFunc:  func(origin string) bool { return strings.HasSuffix(origin, ".localhost:3000") },
+		AllowedMethods:   []string{"GET", "POST"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		Routes: map[string]apig.CORSConfig{
+			"/public": {AllowedOrigins: []string{"*"}},
+		},
+	})
+	defer apig.ResetCORSConfig()
+
+	tests := []struct {
+		path        string
+		origin      string
+		allowOrigin string
+	}{
+		{"/", "https://spalk.tv", "https://spalk.tv"},
+		{"/", "https://api.spalk.tv", "https://api.spalk.tv"},
+		{"/", "https://.spalk.tv", ""},
+		{"/", "http://api.spalk.tv", ""},
+		{"/", "https://evil.tv", ""},
+		{"/", "http://app.localhost:3000", "http://app.localhost:3000"},
+		{"/public/thing", "https://evil.tv", "*"},
+		{"/public", "https://evil.tv", "*"},
+		{"/publicity", "https://evil.tv", ""},
+		{"/public-admin", "https://evil.tv", ""},
+	}
+	for _, tt := range tests {
+		req := events.APIGatewayProxyRequest{Path: tt.path, Headers: map[string]string{"origin": tt.origin}}
+		resp, err := apig.Respond(nil, http.StatusOK, req, nil)
+		require.NoError(t, err)
+		require.Equal(t, tt.allowOrigin, resp.Headers["Access-Control-Allow-Origin"], tt.origin)
+		if tt.allowOrigin != "" && tt.allowOrigin != "*" {
+			require.Equal(t, "true", resp.Headers["Access-Control-Allow-Credentials"])
+			require.Equal(t, "Origin", resp.Headers["Vary"])
+			require.Equal(t, "X-Request-Id", resp.Headers["Access-Control-Expose-Headers"])
+		}
+	}
+}
+
+func TestServeCORSPreflight(t *testing.T) {
+	apig.SetCORSConfig(&apig.CORSConfig{
+		AllowedOrigins: []string{"https://spalk.tv"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"Content-Type"},
+		MaxAge:         10 * time.Minute,
+	})
+	defer apig.ResetCORSConfig()
+
+	called := false
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+		apig.RespondHTTP(rw, errors.New("handler should not see preflights"), http.StatusMethodNotAllowed)
+	})
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: http.MethodOptions,
+		Path:       "/users",
+		Headers: map[string]string{
+			"Origin":                        "https://spalk.tv",
+			"Access-Control-Request-Method": "PUT",
+		},
+	}
+	resp, err := apig.Serve(req, handler)
+	require.NoError(t, err)
+	require.False(t, called)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, []string{"https://spalk.tv"}, resp.MultiValueHeaders["Access-Control-Allow-Origin"])
+	require.Equal(t, []string{"GET,PUT"}, resp.MultiValueHeaders["Access-Control-Allow-Methods"])
+	require.Equal(t, []string{"600"}, resp.MultiValueHeaders["Access-Control-Max-Age"])
+
+	v2req := events.APIGatewayV2HTTPRequest{
+		RawPath: "/users",
+		Headers: map[string]string{"origin": "https://spalk.tv"},
+	}
+	v2req.RequestContext.HTTP.Method = http.MethodGet
+	v2resp, err := apig.ServeV2(v2req, handler)
+	require.NoError(t, err)
+	require.True(t, called)
+	require.Equal(t, "https://spalk.tv", v2resp.Headers["Access-Control-Allow-Origin"])
+	require.Empty(t, v2resp.Headers["Access-Control-Max-Age"])
+}
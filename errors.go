@@ -0,0 +1,134 @@
+package apig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const problemContentType = "application/problem+json"
+
+//HTTPError is an error with the status and message to respond with
+//The cause is logged but never sent to the client, so internal details don't leak into responses
+type HTTPError struct {
+	//Status is the http status code of the response
+	Status int
+	//Message is the public description of the error, sent as the problem detail
+	Message string
+	//Cause is the internal error, it is logged and included in admin notifications only
+	Cause error
+	//Type is a uri identifying the kind of problem, it defaults to about:blank
+	Type string
+	//Fields are extra members of the problem document, such as validation errors
+	Fields map[string]interface{}
+}
+
+//NewHTTPError returns an error that responds with status and message, cause may be nil
+func NewHTTPError(status int, message string, cause error) *HTTPError {
+	return &HTTPError{Status: status, Message: message, Cause: cause}
+}
+
+func (e *HTTPError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%d %s: %v", e.Status, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+//Unwrap returns the cause, so errors.Is and errors.As see through an HTTPError
+func (e *HTTPError) Unwrap() error {
+	return e.Cause
+}
+
+//WithField adds a member to the problem document and returns the error for chaining
+func (e *HTTPError) WithField(key string, value interface{}) *HTTPError {
+	if e.Fields == nil {
+		e.Fields = make(map[string]interface{})
+	}
+	e.Fields[key] = value
+	return e
+}
+
+//Problem is an RFC 7807 problem details document
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	//Fields are extension members, they can't replace the standard members
+	Fields map[string]interface{}
+}
+
+//MarshalJSON writes the extension members alongside the standard members
+func (p Problem) MarshalJSON() ([]byte, error) {
+	doc := make(map[string]interface{}, len(p.Fields)+5)
+	for key, value := range p.Fields {
+		doc[key] = value
+	}
+	doc["type"] = p.Type
+	doc["title"] = p.Title
+	doc["status"] = p.Status
+	if p.Detail != "" {
+		doc["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		doc["instance"] = p.Instance
+	}
+	return json.Marshal(doc)
+}
+
+//problemFor builds the problem document for err, logging the cause and notifying admins of server errors
+//status is used for errors that aren't an HTTPError, defaulting to 500 when it isn't an error status
+func problemFor(err error, status int, instance string) Problem {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		if status < 400 {
+			status = http.StatusInternalServerError
+		}
+		httpErr = &HTTPError{Status: status, Cause: err}
+	}
+	problem := Problem{
+		Type:     httpErr.Type,
+		Title:    http.StatusText(httpErr.Status),
+		Status:   httpErr.Status,
+		Detail:   httpErr.Message,
+		Instance: instance,
+		Fields:   httpErr.Fields,
+	}
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if problem.Title == "" {
+		problem.Title = fmt.Sprintf("Status %d", httpErr.Status)
+	}
+	logger.Println(err.Error())
+	if httpErr.Status >= 500 {
+		data := map[string]interface{}{"status": httpErr.Status, "error": err.Error()}
+		if instance != "" {
+			data["instance"] = instance
+		}
+		logger.NotifyAdmin(problem.Title+": "+err.Error(), data)
+	}
+	return problem
+}
+
+//RespondError writes err to rw as an application/problem+json document
+//An HTTPError responds with its status and message, any other error with a 500 that doesn't reveal it
+func RespondError(rw http.ResponseWriter, err error) {
+	writeProblem(rw, problemFor(err, http.StatusInternalServerError, ""))
+}
+
+func writeProblem(rw http.ResponseWriter, problem Problem) {
+	body, err := json.Marshal(problem)
+	if err != nil {
+		logger.Println(err.Error())
+		http.Error(rw, http.StatusText(problem.Status), problem.Status)
+		return
+	}
+	rw.Header().Set("Content-Type", problemContentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(problem.Status)
+	rw.Write(body)
+}
@@ -0,0 +1,89 @@
+package apig_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/slogger"
+	"github.com/SpalkLtd/slogger/notifiers/testNotifier"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func testNotifierLogger() *testNotifier.T {
+	logger := slogger.NewLogger()
+	tNot := testNotifier.New()
+	logger.SetNotifier(tNot)
+	logger.SetDefaultLogger()
+	apig.SetLogger(logger)
+	return tNot
+}
+
+func TestRespondHTTPError(t *testing.T) {
+	tNot := testNotifierLogger()
+	cause := errors.New("pq: duplicate key value violates unique constraint")
+	err := apig.NewHTTPError(http.StatusConflict, "A user with that email already exists", cause).WithField("field", "email")
+	require.True(t, errors.Is(err, cause))
+
+	rw := httptest.NewRecorder()
+	apig.RespondHTTP(rw, fmt.Errorf("creating user: %w", err), http.StatusOK)
+	require.Equal(t, http.StatusConflict, rw.Code)
+	require.Equal(t, "application/problem+json", rw.Header().Get("Content-Type"))
+	require.NotContains(t, rw.Body.String(), "pq:")
+	var problem map[string]interface{}
+	require.NoError(t, json.Unmarshal(rw.Body.Bytes(), &problem))
+	require.Equal(t, map[string]interface{}{
+		"type":   "about:blank",
+		"title":  "Conflict",
+		"status": float64(http.StatusConflict),
+		"detail": "A user with that email already exists",
+		"field":  "email",
+	}, problem)
+	require.False(t, tNot.GotNotification("pq:"))
+}
+
+func TestRespondHTTPInternalError(t *testing.T) {
+	tNot := testNotifierLogger()
+	rw := httptest.NewRecorder()
+	apig.RespondHTTP(rw, errors.New("dial tcp 10.0.0.1:5432: connection refused"), http.StatusOK)
+	require.Equal(t, http.StatusInternalServerError, rw.Code)
+	require.NotContains(t, rw.Body.String(), "10.0.0.1")
+	require.JSONEq(t, `{"type":"about:blank","title":"Internal Server Error","status":500}`, rw.Body.String())
+	require.True(t, tNot.GotNotification("connection refused"))
+}
+
+func TestRespondProblem(t *testing.T) {
+	testNotifierLogger()
+	notFound := apig.NewHTTPError(http.StatusNotFound, "No such user", nil)
+
+	resp, err := apig.Respond(nil, http.StatusOK, events.APIGatewayProxyRequest{Path: "/users/1"}, notFound)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, "application/problem+json", resp.Headers["Content-Type"])
+	require.JSONEq(t, `{"type":"about:blank","title":"Not Found","status":404,"detail":"No such user","instance":"/users/1"}`, resp.Body)
+
+	respV2, err := apig.RespondV2(nil, http.StatusBadRequest, events.APIGatewayV2HTTPRequest{RawPath: "/users"}, errors.New("internal detail"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, respV2.StatusCode)
+	require.Equal(t, "application/problem+json", respV2.Headers["Content-Type"])
+	require.JSONEq(t, `{"type":"about:blank","title":"Bad Request","status":400,"instance":"/users"}`, respV2.Body)
+
+	//requests that can't be converted don't leak the error
+	albResp, err := apig.ServeALB(events.ALBTargetGroupRequest{HTTPMethod: http.MethodPost, Path: "/users", Body: "not base64!", IsBase64Encoded: true}, http.NotFoundHandler())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, albResp.StatusCode)
+	require.Equal(t, "application/problem+json", albResp.Headers["Content-Type"])
+	require.JSONEq(t, `{"type":"about:blank","title":"Internal Server Error","status":500,"instance":"/users"}`, albResp.Body)
+
+	//an explicit body is still sent as is
+	resp, err = apig.Respond(map[string]string{"error": "custom"}, http.StatusOK, events.APIGatewayProxyRequest{}, notFound)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Headers["Content-Type"])
+	require.JSONEq(t, `{"error":"custom"}`, resp.Body)
+}
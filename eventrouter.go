@@ -0,0 +1,198 @@
+package apig
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+//EventRouter dispatches non http lambda events to typed handlers
+//Use its Handle method as the fallback of LambdaHandlerWithContext, so handlers get the invocation's deadline and lambda context
+type EventRouter struct {
+	sqs         func(context.Context, events.SQSEvent) (events.SQSEventResponse, error)
+	sns         func(context.Context, events.SNSEvent) error
+	s3          func(context.Context, events.S3Event) error
+	eventBridge func(context.Context, events.CloudWatchEvent) error
+	schedule    func(context.Context, events.CloudWatchEvent) error
+	dynamoDB    func(context.Context, events.DynamoDBEvent) (events.DynamoDBEventResponse, error)
+	kinesis     func(context.Context, events.KinesisEvent) (events.KinesisEventResponse, error)
+	fallback    lambdaContextHandlerFunc
+}
+
+//NewEventRouter creates a router with no handlers
+func NewEventRouter() *EventRouter {
+	return &EventRouter{}
+}
+
+//OnSQS handles sqs events, the response reports the messages that failed so only they are retried
+func (er *EventRouter) OnSQS(handler func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error)) *EventRouter {
+	er.sqs = handler
+	return er
+}
+
+//OnSNS handles sns notifications
+func (er *EventRouter) OnSNS(handler func(ctx context.Context, event events.SNSEvent) error) *EventRouter {
+	er.sns = handler
+	return er
+}
+
+//OnS3 handles s3 bucket notifications
+func (er *EventRouter) OnS3(handler func(ctx context.Context, event events.S3Event) error) *EventRouter {
+	er.s3 = handler
+	return er
+}
+
+//OnEventBridge handles eventbridge (cloudwatch events) events, including scheduled events unless OnSchedule is set
+func (er *EventRouter) OnEventBridge(handler func(ctx context.Context, event events.CloudWatchEvent) error) *EventRouter {
+	er.eventBridge = handler
+	return er
+}
+
+//OnSchedule handles scheduled eventbridge events, from cron and rate expressions
+func (er *EventRouter) OnSchedule(handler func(ctx context.Context, event events.CloudWatchEvent) error) *EventRouter {
+	er.schedule = handler
+	return er
+}
+
+//OnDynamoDB handles dynamodb stream records, the response reports the records that failed
+func (er *EventRouter) OnDynamoDB(handler func(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error)) *EventRouter {
+	er.dynamoDB = handler
+	return er
+}
+
+//OnKinesis handles kinesis stream records, the response reports the records that failed
+func (er *EventRouter) OnKinesis(handler func(ctx context.Context, event events.KinesisEvent) (events.KinesisEventResponse, error)) *EventRouter {
+	er.kinesis = handler
+	return er
+}
+
+//Fallback handles events the router doesn't recognise or has no handler for, without one they return ErrNoHandler
+func (er *EventRouter) Fallback(handler func(ctx context.Context, event json.RawMessage) (interface{}, error)) *EventRouter {
+	er.fallback = handler
+	return er
+}
+
+//HandleEvent is Handle without a context, for LambdaHandler and StartLambda
+//The handlers are called with context.Background(), so they don't see the invocation's deadline or lambda context
+//Prefer Handle as the fallback of LambdaHandlerWithContext, started with lambda.Start
+func (er *EventRouter) HandleEvent(event json.RawMessage) (interface{}, error) {
+	return er.Handle(context.Background(), event)
+}
+
+//Handle detects the type of event and calls its handler
+func (er *EventRouter) Handle(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	switch kind := detectRecordEvent(event); kind {
+	case sqsEvent:
+		if er.sqs != nil {
+			var e events.SQSEvent
+			if err := json.Unmarshal(event, &e); err != nil {
+				return nil, err
+			}
+			return er.sqs(ctx, e)
+		}
+	case snsEvent:
+		if er.sns != nil {
+			var e events.SNSEvent
+			if err := json.Unmarshal(event, &e); err != nil {
+				return nil, err
+			}
+			return nil, er.sns(ctx, e)
+		}
+	case s3Event:
+		if er.s3 != nil {
+			var e events.S3Event
+			if err := json.Unmarshal(event, &e); err != nil {
+				return nil, err
+			}
+			return nil, er.s3(ctx, e)
+		}
+	case scheduledEvent, eventBridgeEvent:
+		handler := er.eventBridge
+		if er.schedule != nil && kind == scheduledEvent {
+			handler = er.schedule
+		}
+		if handler != nil {
+			var e events.CloudWatchEvent
+			if err := json.Unmarshal(event, &e); err != nil {
+				return nil, err
+			}
+			return nil, handler(ctx, e)
+		}
+	case dynamoDBEvent:
+		if er.dynamoDB != nil {
+			var e events.DynamoDBEvent
+			if err := json.Unmarshal(event, &e); err != nil {
+				return nil, err
+			}
+			return er.dynamoDB(ctx, e)
+		}
+	case kinesisEvent:
+		if er.kinesis != nil {
+			var e events.KinesisEvent
+			if err := json.Unmarshal(event, &e); err != nil {
+				return nil, err
+			}
+			return er.kinesis(ctx, e)
+		}
+	}
+	if er.fallback != nil {
+		return er.fallback(ctx, event)
+	}
+	return nil, ErrNoHandler
+}
+
+//recordEventKind is the type of a non http event
+type recordEventKind int
+
+const (
+	unknownRecordEvent recordEventKind = iota
+	sqsEvent
+	snsEvent
+	s3Event
+	eventBridgeEvent
+	scheduledEvent
+	dynamoDBEvent
+	kinesisEvent
+)
+
+//recordEventProbe picks out the fields used to tell non http events apart
+//SNS records capitalise EventSource, json matches the exact key to the right field
+type recordEventProbe struct {
+	Records []struct {
+		EventSource    string `json:"eventSource"`
+		SNSEventSource string `json:"EventSource"`
+	} `json:"Records"`
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+}
+
+//detectRecordEvent works out which service sent an event from the event source of its first record, or the eventbridge detail type
+func detectRecordEvent(event json.RawMessage) recordEventKind {
+	var probe recordEventProbe
+	if err := json.Unmarshal(event, &probe); err != nil {
+		return unknownRecordEvent
+	}
+	if probe.DetailType != "" && probe.Source != "" {
+		if probe.Source == "aws.events" && probe.DetailType == "Scheduled Event" {
+			return scheduledEvent
+		}
+		return eventBridgeEvent
+	}
+	if len(probe.Records) == 0 {
+		return unknownRecordEvent
+	}
+	switch record := probe.Records[0]; {
+	case record.SNSEventSource == "aws:sns":
+		return snsEvent
+	case record.EventSource == "aws:sqs":
+		return sqsEvent
+	case record.EventSource == "aws:s3":
+		return s3Event
+	case record.EventSource == "aws:dynamodb":
+		return dynamoDBEvent
+	case record.EventSource == "aws:kinesis":
+		return kinesisEvent
+	}
+	return unknownRecordEvent
+}
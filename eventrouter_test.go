@@ -0,0 +1,102 @@
+package apig_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testSQSEvent       = `{"Records":[{"messageId":"m1","receiptHandle":"r1","body":"hello","eventSource":"aws:sqs","eventSourceARN":"arn:aws:sqs:us-east-1:123456789012:queue","awsRegion":"us-east-1"}]}`
+	testSNSEvent       = `{"Records":[{"EventSource":"aws:sns","EventVersion":"1.0","EventSubscriptionArn":"arn:aws:sns:us-east-1:123456789012:topic:sub","Sns":{"MessageId":"n1","Message":"hello","TopicArn":"arn:aws:sns:us-east-1:123456789012:topic"}}]}`
+	testS3Event        = `{"Records":[{"eventVersion":"2.1","eventSource":"aws:s3","awsRegion":"us-east-1","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"uploads"},"object":{"key":"photo.jpg","size":1024}}}]}`
+	testScheduledEvent = `{"version":"0","id":"e1","detail-type":"Scheduled Event","source":"aws.events","account":"123456789012","time":"2024-01-01T00:00:00Z","region":"us-east-1","resources":["arn:aws:events:us-east-1:123456789012:rule/nightly"],"detail":{}}`
+	testCustomEvent    = `{"version":"0","id":"e2","detail-type":"UserSignedUp","source":"com.example.users","account":"123456789012","time":"2024-01-01T00:00:00Z","region":"us-east-1","resources":[],"detail":{"userId":"u1"}}`
+	testDynamoDBEvent  = `{"Records":[{"eventID":"d1","eventName":"INSERT","eventSource":"aws:dynamodb","awsRegion":"us-east-1","dynamodb":{"Keys":{"id":{"S":"u1"}},"SequenceNumber":"100","StreamViewType":"KEYS_ONLY"}}]}`
+	testKinesisEvent   = `{"Records":[{"eventID":"k1","eventSource":"aws:kinesis","eventName":"aws:kinesis:record","awsRegion":"us-east-1","kinesis":{"partitionKey":"p1","sequenceNumber":"200","data":"aGVsbG8="}}]}`
+)
+
+func TestEventRouter(t *testing.T) {
+	var got []string
+	router := apig.NewEventRouter().
+		OnSQS(func(ctx context.Context, e events.SQSEvent) (events.SQSEventResponse, error) {
+			got = append(got, "sqs "+e.Records[0].Body)
+			return events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{{ItemIdentifier: e.Records[0].MessageId}}}, nil
+		}).
+		OnSNS(func(ctx context.Context, e events.SNSEvent) error {
+			got = append(got, "sns "+e.Records[0].SNS.Message)
+			return nil
+		}).
+		OnS3(func(ctx context.Context, e events.S3Event) error {
+			got = append(got, "s3 "+e.Records[0].S3.Object.Key)
+			return nil
+		}).
+		OnEventBridge(func(ctx context.Context, e events.CloudWatchEvent) error {
+			got = append(got, "eventbridge "+e.DetailType)
+			return nil
+		}).
+		OnSchedule(func(ctx context.Context, e events.CloudWatchEvent) error {
+			got = append(got, "schedule "+e.Resources[0])
+			return nil
+		}).
+		OnDynamoDB(func(ctx context.Context, e events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+			got = append(got, "dynamodb "+e.Records[0].Change.Keys["id"].String())
+			return events.DynamoDBEventResponse{}, nil
+		}).
+		OnKinesis(func(ctx context.Context, e events.KinesisEvent) (events.KinesisEventResponse, error) {
+			got = append(got, "kinesis "+string(e.Records[0].Kinesis.Data))
+			return events.KinesisEventResponse{}, errors.New("kinesis failed")
+		})
+
+	resp, err := router.HandleEvent(json.RawMessage(testSQSEvent))
+	require.NoError(t, err)
+	require.Equal(t, events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{{ItemIdentifier: "m1"}}}, resp)
+	for _, event := range []string{testSNSEvent, testS3Event, testScheduledEvent, testCustomEvent, testDynamoDBEvent} {
+		_, err = router.Handle(context.Background(), json.RawMessage(event))
+		require.NoError(t, err)
+	}
+	_, err = router.Handle(context.Background(), json.RawMessage(testKinesisEvent))
+	require.EqualError(t, err, "kinesis failed")
+	require.Equal(t, []string{
+		"sqs hello",
+		"sns hello",
+		"s3 photo.jpg",
+		"schedule arn:aws:events:us-east-1:123456789012:rule/nightly",
+		"eventbridge UserSignedUp",
+		"dynamodb u1",
+		"kinesis hello",
+	}, got)
+
+	_, err = router.HandleEvent(json.RawMessage(`{"custom":true}`))
+	require.Equal(t, apig.ErrNoHandler, err)
+}
+
+func TestEventRouterFallback(t *testing.T) {
+	var scheduled []string
+	router := apig.NewEventRouter().
+		OnEventBridge(func(ctx context.Context, e events.CloudWatchEvent) error {
+			scheduled = append(scheduled, e.DetailType)
+			return nil
+		}).
+		Fallback(func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+			return "fallback", nil
+		})
+	handler := apig.LambdaHandler(http.NotFoundHandler(), router.HandleEvent)
+
+	//scheduled events go to OnEventBridge without an OnSchedule handler
+	_, err := handler(json.RawMessage(testScheduledEvent))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Scheduled Event"}, scheduled)
+
+	for _, event := range []string{testSQSEvent, `{"custom":true}`} {
+		resp, err := handler(json.RawMessage(event))
+		require.NoError(t, err)
+		require.Equal(t, "fallback", resp)
+	}
+}
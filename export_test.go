@@ -0,0 +1,7 @@
+package apig
+
+//ResetCORSConfig restores the CORS policy to its state before SetCORSConfig was called
+func ResetCORSConfig() {
+	corsConfig = DefaultCORSConfig()
+	serveCORSConfig = nil
+}
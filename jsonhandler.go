@@ -0,0 +1,178 @@
+package apig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+//Validator is implemented by request types that check themselves, JSON responds with a 400 when Validate returns an error
+//Return an HTTPError to choose a different status or add fields to the problem document
+type Validator interface {
+	Validate() error
+}
+
+//JSON adapts a typed function into an http handler
+//The request body is decoded into In, rejecting unknown fields, then struct fields tagged `path:"name"` or `query:"name"` are set from the path parameters and query string, overriding the body
+//If In implements Validator it is validated before fn is called
+//The result of fn is written as json with a 200, errors are written with RespondError so an HTTPError chooses the status
+func JSON[In, Out any](fn func(ctx context.Context, in In) (Out, error)) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var in In
+		if err := decodeJSONRequest(r, &in); err != nil {
+			RespondError(rw, err)
+			return
+		}
+		out, err := fn(r.Context(), in)
+		if err != nil {
+			RespondError(rw, err)
+			return
+		}
+		body, err := json.Marshal(out)
+		if err != nil {
+			RespondError(rw, err)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		RespondHTTP(rw, body, http.StatusOK)
+	})
+}
+
+//decodeJSONRequest fills in, a pointer to the request type, from the body, path and query of r and validates it
+func decodeJSONRequest(r *http.Request, in interface{}) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return NewHTTPError(http.StatusBadRequest, "Unable to read request body", err)
+		}
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(in); err != nil {
+			return NewHTTPError(http.StatusBadRequest, "Invalid request body: "+err.Error(), err)
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return NewHTTPError(http.StatusBadRequest, "Invalid request body: unexpected data after the json value", err)
+		}
+	}
+	v := reflect.ValueOf(in).Elem()
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		if err := setTaggedFields(v, r); err != nil {
+			return err
+		}
+	}
+	validator, ok := v.Addr().Interface().(Validator)
+	if !ok {
+		validator, ok = reflect.ValueOf(in).Elem().Interface().(Validator)
+	}
+	if ok {
+		if err := validator.Validate(); err != nil {
+			var httpErr *HTTPError
+			if errors.As(err, &httpErr) {
+				return err
+			}
+			return NewHTTPError(http.StatusBadRequest, err.Error(), err)
+		}
+	}
+	return nil
+}
+
+//setTaggedFields sets the fields of v tagged with path or query from the request
+func setTaggedFields(v reflect.Value, r *http.Request) error {
+	params := PathParameters(r)
+	query := r.URL.Query()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		var values []string
+		var name, source string
+		if name = field.Tag.Get("path"); name != "" {
+			source = "path parameter"
+			if value, ok := params[name]; ok {
+				values = []string{value}
+			}
+		} else if name = field.Tag.Get("query"); name != "" {
+			source = "query parameter"
+			values = query[name]
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(v.Field(i), values); err != nil {
+			return NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid %s %s", source, name), err).WithField("parameter", name)
+		}
+	}
+	return nil
+}
+
+//setField parses values into a field of a basic type, a pointer to one or a slice of them
+func setField(field reflect.Value, values []string) error {
+	switch field.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), values); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	case reflect.Slice:
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setField(slice.Index(i), []string{value}); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	value := values[len(values)-1]
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
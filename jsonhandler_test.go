@@ -0,0 +1,100 @@
+package apig_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+)
+
+type updateUser struct {
+	ID     int      `json:"-" path:"id"`
+	Notify *bool    `json:"-" query:"notify"`
+	Tags   []string `json:"-" query:"tag"`
+	Name   string   `json:"name"`
+	Email  string   `json:"email"`
+}
+
+func (u updateUser) Validate() error {
+	if u.Name == "" {
+		return errors.New("name is required")
+	}
+	if u.Email == "taken@example.com" {
+		return apig.NewHTTPError(http.StatusConflict, "Email is already in use", nil)
+	}
+	return nil
+}
+
+type user struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Email  string   `json:"email"`
+	Notify bool     `json:"notify"`
+	Tags   []string `json:"tags"`
+}
+
+func TestJSONHandler(t *testing.T) {
+	testNotifierLogger()
+	handler := apig.JSON(func(ctx context.Context, in updateUser) (user, error) {
+		if in.ID == 404 {
+			return user{}, apig.NewHTTPError(http.StatusNotFound, "No such user", nil)
+		}
+		if in.Name == "broken" {
+			return user{}, errors.New("pq: connection refused")
+		}
+		return user{ID: in.ID, Name: in.Name, Email: in.Email, Notify: in.Notify != nil && *in.Notify, Tags: in.Tags}, nil
+	})
+	request := func(id string, body string) *apigtest.V1Request {
+		return apigtest.NewV1Request("PUT", "/users/"+id).WithResource("/users/{id}", map[string]string{"id": id}).WithBody(body)
+	}
+
+	apigtest.Serve(t, handler, request("42", `{"name":"Ann","email":"ann@example.com"}`).WithQuery("notify", "true").WithQuery("tag", "a", "b")).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/json").
+		AssertJSON(`{"id":42,"name":"Ann","email":"ann@example.com","notify":true,"tags":["a","b"]}`)
+
+	apigtest.Serve(t, handler, request("42", `{"name":"Ann","admin":true}`)).
+		AssertStatus(http.StatusBadRequest).
+		AssertJSON(`{"type":"about:blank","title":"Bad Request","status":400,"detail":"Invalid request body: json: unknown field \"admin\""}`)
+
+	apigtest.Serve(t, handler, request("42", `{"name":"Ann"} {}`)).
+		AssertStatus(http.StatusBadRequest)
+
+	apigtest.Serve(t, handler, request("abc", `{"name":"Ann"}`)).
+		AssertStatus(http.StatusBadRequest).
+		AssertJSON(`{"type":"about:blank","title":"Bad Request","status":400,"detail":"Invalid path parameter id","parameter":"id"}`)
+
+	apigtest.Serve(t, handler, request("42", `{"email":"ann@example.com"}`)).
+		AssertStatus(http.StatusBadRequest).
+		AssertJSON(`{"type":"about:blank","title":"Bad Request","status":400,"detail":"name is required"}`)
+
+	apigtest.Serve(t, handler, request("42", `{"name":"Ann","email":"taken@example.com"}`)).
+		AssertStatus(http.StatusConflict)
+
+	apigtest.Serve(t, handler, request("404", `{"name":"Ann"}`)).
+		AssertStatus(http.StatusNotFound).
+		AssertJSON(`{"type":"about:blank","title":"Not Found","status":404,"detail":"No such user"}`)
+
+	apigtest.Serve(t, handler, request("42", `{"name":"broken"}`)).
+		AssertStatus(http.StatusInternalServerError).
+		AssertJSON(`{"type":"about:blank","title":"Internal Server Error","status":500}`)
+}
+
+type listUsers struct {
+	Limit int `query:"limit"`
+}
+
+func TestJSONHandlerWithoutBody(t *testing.T) {
+	testNotifierLogger()
+	handler := apig.JSON(func(ctx context.Context, in *listUsers) ([]user, error) {
+		return make([]user, in.Limit), nil
+	})
+	apigtest.ServeV2(t, handler, apigtest.NewV2Request("GET", "/users").WithQuery("limit", "2")).
+		AssertStatus(http.StatusOK).
+		AssertJSON(`[{"id":0,"name":"","email":"","notify":false,"tags":null},{"id":0,"name":"","email":"","notify":false,"tags":null}]`)
+	apigtest.ServeV2(t, handler, apigtest.NewV2Request("GET", "/users").WithQuery("limit", "many")).
+		AssertStatus(http.StatusBadRequest)
+}
@@ -0,0 +1,196 @@
+package apig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+//LocalOptions controls how LocalHandler and ListenAndServeLocal emulate apigateway
+type LocalOptions struct {
+	//Stage is the stage the events are sent from, it defaults to "local" for payload format 1.0 and "$default" for 2.0
+	Stage string
+	//StagePrefix expects request paths to start with /{Stage}, as they do on execute-api urls
+	//The stage is stripped from the path of 1.0 events and kept in the rawPath of 2.0 events, as apigateway does, list it in StageMapping.Stages for ServeV2 to remove it
+	StagePrefix bool
+	//StageVariables are sent with every event
+	StageVariables map[string]string
+	//AuthorizerClaims are sent as though an authorizer had verified them, as requestContext.authorizer.claims for 1.0 and requestContext.authorizer.jwt.claims for 2.0
+	AuthorizerClaims map[string]interface{}
+	//PayloadVersion is the payload format version, "1.0" (the default) or "2.0"
+	PayloadVersion string
+}
+
+func (opts LocalOptions) stage() string {
+	if opts.Stage != "" {
+		return opts.Stage
+	}
+	if opts.PayloadVersion == "2.0" {
+		return "$default"
+	}
+	return "local"
+}
+
+//ListenAndServeLocal serves handler on addr the way apigateway would, converting each request to an event and back
+//This lets handlers be run locally with the same conversion quirks they will see in production
+func ListenAndServeLocal(addr string, handler http.Handler, opts LocalOptions) error {
+	return http.ListenAndServe(addr, LocalHandler(LambdaHandlerWithContext(handler, nil), opts))
+}
+
+//LocalHandler returns an http handler that converts requests to apigateway events, invokes the lambda handler with them and writes out the response it returns
+func LocalHandler(invoke lambdaContextHandlerFunc, opts LocalOptions) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		event, err := localEvent(r, opts)
+		if err != nil {
+			logger.Println(err.Error())
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := invoke(r.Context(), event)
+		if err != nil {
+			//this is what apigateway returns when the lambda fails
+			logger.Println(err.Error())
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusBadGateway)
+			rw.Write([]byte(`{"message":"Internal server error"}`))
+			return
+		}
+		if err = writeLocalResponse(rw, resp); err != nil {
+			logger.Println(err.Error())
+		}
+	})
+}
+
+//localEvent converts a request into the json event apigateway would send for it
+func localEvent(r *http.Request, opts LocalOptions) (json.RawMessage, error) {
+	path := r.URL.Path
+	if opts.StagePrefix {
+		prefix := "/" + opts.stage()
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			return nil, fmt.Errorf("Path %s is not in stage %s", path, opts.stage())
+		}
+		//rest apis leave the stage out of the event path, http apis keep it in rawPath
+		if opts.PayloadVersion != "2.0" {
+			r = r.Clone(r.Context())
+			r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, prefix), "/")
+			r.URL.RawPath = ""
+		}
+	}
+	requestID := newRequestID()
+	if opts.PayloadVersion == "2.0" {
+		event, err := ToApigRequestV2(*r)
+		if err != nil {
+			return nil, err
+		}
+		event.RequestContext.Stage = opts.stage()
+		event.RequestContext.RequestID = requestID
+		event.StageVariables = opts.StageVariables
+		if opts.AuthorizerClaims != nil {
+			claims := make(map[string]string, len(opts.AuthorizerClaims))
+			for k, v := range opts.AuthorizerClaims {
+				claims[k] = fmt.Sprint(v)
+			}
+			event.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{Claims: claims},
+			}
+		}
+		return json.Marshal(event)
+	}
+	event, err := ToApigRequest(*r)
+	if err != nil {
+		return nil, err
+	}
+	event.Path = r.URL.Path
+	event.RequestContext.Stage = opts.stage()
+	event.RequestContext.RequestID = requestID
+	event.RequestContext.HTTPMethod = r.Method
+	event.RequestContext.Path = path
+	event.StageVariables = opts.StageVariables
+	if opts.AuthorizerClaims != nil {
+		event.RequestContext.Authorizer = map[string]interface{}{"claims": opts.AuthorizerClaims}
+	}
+	return json.Marshal(event)
+}
+
+//localResponse has the fields of both apigateway response formats
+type localResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+	Cookies           []string            `json:"cookies"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+//writeLocalResponse writes the response returned by a lambda handler the way apigateway would
+func writeLocalResponse(rw http.ResponseWriter, resp interface{}) error {
+	shr, err := toLocalResponse(resp)
+	if err != nil {
+		return err
+	}
+	for key, values := range shr.Header {
+		rw.Header()[key] = values
+	}
+	rw.WriteHeader(shr.StatusCode)
+	_, err = io.Copy(rw, shr.Body)
+	return err
+}
+
+//toLocalResponse converts the response returned by a lambda handler, in any of the apigateway formats, into a std library response
+func toLocalResponse(resp interface{}) (*http.Response, error) {
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	var lr localResponse
+	if err = json.Unmarshal(raw, &lr); err != nil {
+		return nil, err
+	}
+	body, err := decodeBody(lr.Body, lr.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+	if lr.StatusCode == 0 {
+		lr.StatusCode = http.StatusOK
+	}
+	shr := &http.Response{
+		Status:        fmt.Sprintf("%d %s", lr.StatusCode, http.StatusText(lr.StatusCode)),
+		StatusCode:    lr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	for key, value := range lr.Headers {
+		shr.Header.Set(key, value)
+	}
+	for key, values := range lr.MultiValueHeaders {
+		shr.Header.Del(key)
+		for _, v := range values {
+			shr.Header.Add(key, v)
+		}
+	}
+	for _, cookie := range lr.Cookies {
+		shr.Header.Add("Set-Cookie", cookie)
+	}
+	return shr, nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
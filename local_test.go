@@ -0,0 +1,98 @@
+package apig_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//localEchoHandler is served on the local server's goroutines, so it uses assert rather than require
+func localEchoHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		echo := map[string]interface{}{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"query":  r.URL.Query(),
+			"stage":  apig.StageVariable(r, "env"),
+			"body":   string(body),
+		}
+		if reqCtx, ok := apig.RequestContextFrom(r); ok {
+			echo["requestStage"] = reqCtx.Stage
+			echo["claims"] = reqCtx.Authorizer["claims"]
+		}
+		if event, ok := apig.EventFrom(r).(events.APIGatewayV2HTTPRequest); ok {
+			echo["rawPath"] = event.RawPath
+		}
+		if reqCtx, ok := apig.V2RequestContextFrom(r); ok && reqCtx.Authorizer != nil {
+			echo["requestStage"] = reqCtx.Stage
+			echo["claims"] = reqCtx.Authorizer.JWT.Claims
+		}
+		if c, err := r.Cookie("session"); err == nil {
+			echo["session"] = c.Value
+		}
+		http.SetCookie(rw, &http.Cookie{Name: "a", Value: "1"})
+		http.SetCookie(rw, &http.Cookie{Name: "b", Value: "2"})
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(echo)
+	})
+}
+
+func TestLocalHandler(t *testing.T) {
+	apig.SetStageMapping(apig.StageMapping{Stages: []string{"dev"}})
+	defer apig.SetStageMapping(apig.StageMapping{})
+	for _, version := range []string{"1.0", "2.0"} {
+		t.Run(version, func(t *testing.T) {
+			opts := apig.LocalOptions{
+				Stage:            "dev",
+				StagePrefix:      true,
+				StageVariables:   map[string]string{"env": "local"},
+				AuthorizerClaims: map[string]interface{}{"sub": "user-1"},
+				PayloadVersion:   version,
+			}
+			server := httptest.NewServer(apig.LocalHandler(apig.LambdaHandlerWithContext(localEchoHandler(t), nil), opts))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodPost, server.URL+"/dev/users/a%20b?tag=x&tag=y%26z", strings.NewReader(`{"name":"zoë"}`))
+			require.NoError(t, err)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			require.Len(t, resp.Cookies(), 2)
+			var echo map[string]interface{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&echo))
+			require.Equal(t, "POST", echo["method"])
+			require.Equal(t, "/users/a b", echo["path"])
+			require.Equal(t, map[string]interface{}{"tag": []interface{}{"x", "y&z"}}, echo["query"])
+			require.Equal(t, "local", echo["stage"])
+			require.Equal(t, "dev", echo["requestStage"])
+			require.Equal(t, map[string]interface{}{"sub": "user-1"}, echo["claims"])
+			require.Equal(t, "abc", echo["session"])
+			require.Equal(t, `{"name":"zoë"}`, echo["body"])
+			if version == "2.0" {
+				//http apis keep the stage in rawPath, ServeV2 removes it for the handler
+				require.Equal(t, "/dev/users/a%20b", echo["rawPath"])
+			} else {
+				require.Nil(t, echo["rawPath"])
+			}
+
+			resp, err = http.Get(server.URL + "/prod/users")
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
@@ -2,77 +2,188 @@ package apig
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
-	"regexp"
+	"net/url"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
-var re = regexp.MustCompile(`(?m)\w+.execute-api.[\w-]+.amazonaws.com`)
-
 //ToStdLibRequest converts the parsed json message into the format expected by the std library
 func ToStdLibRequest(req events.APIGatewayProxyRequest) (*http.Request, error) {
-	// spew.Fdump(os.Stderr, req)
-	queryString := "?"
+	query := url.Values{}
 	for key, values := range req.MultiValueQueryStringParameters {
-		for _, value := range values {
-			if len(queryString) > 1 {
-				queryString += "&"
-			}
-			queryString += key + "=" + value
+		query[key] = append(query[key], values...)
+	}
+	if len(req.MultiValueQueryStringParameters) == 0 {
+		for key, value := range req.QueryStringParameters {
+			query.Set(key, value)
 		}
 	}
-	shr, err := http.NewRequest(req.HTTPMethod, "https://host"+req.Path+queryString, bytes.NewBuffer([]byte(req.Body)))
+	body, err := decodeBody(req.Body, req.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+	//the rest api path has already been decoded, so it is escaped again for the url
+	shr, err := newStdLibRequest(req.HTTPMethod, req.Path, "", query.Encode(), body)
 	if err != nil {
 		return shr, err
 	}
 
-	shr.Host = req.Headers["Host"]
-	shr.URL.Host = req.Headers["Host"]
-	// If we are on an aws domain, add the request Stage to the host
-	// (as it sneaks into the url path but is not considered in the "Path")
-	if re.MatchString(req.Headers["Host"]) {
-		shr.Host = shr.Host + "/" + req.RequestContext.Stage
-		shr.URL.Host = shr.URL.Host + "/" + req.RequestContext.Stage
+	//multiValueHeaders holds every header value, where headers only has the last of each
+	for key, values := range req.MultiValueHeaders {
+		for _, value := range values {
+			shr.Header.Add(key, value)
+		}
+	}
+	if len(req.MultiValueHeaders) == 0 {
+		for key, value := range req.Headers {
+			shr.Header.Add(key, value)
+		}
 	}
-	shr.URL.Scheme = req.Headers["CloudFront-Forwarded-Proto"]
+	shr.Host = shr.Header.Get("Host")
+	shr.URL.Host = shr.Header.Get("Host")
+	//rest apis leave the stage out of the path on execute-api hosts
+	shr.URL.Path = stageMapping.route(shr.URL.Path, shr.Host, req.RequestContext.Stage, false)
+	shr = withStage(shr, req.RequestContext.Stage)
+	shr.URL.Scheme = forwardedProto(shr.Header)
 	shr.RemoteAddr = req.RequestContext.Identity.SourceIP
+	return shr, err
+}
+
+//ToStdLibRequestV2 converts the parsed payload format 2.0 json message into the format expected by the std library
+//The raw path and raw query string are used as is, so the request sees exactly what the client sent
+func ToStdLibRequestV2(req events.APIGatewayV2HTTPRequest) (*http.Request, error) {
+	rawQuery := req.RawQueryString
+	if rawQuery == "" && len(req.QueryStringParameters) > 0 {
+		query := url.Values{}
+		for key, value := range req.QueryStringParameters {
+			query[key] = strings.Split(value, ",")
+		}
+		rawQuery = query.Encode()
+	}
+	path := req.RequestContext.HTTP.Path
+	if path == "" {
+		path = req.RawPath
+	}
+	body, err := decodeBody(req.Body, req.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+	shr, err := newStdLibRequest(req.RequestContext.HTTP.Method, path, req.RawPath, rawQuery, body)
+	if err != nil {
+		return shr, err
+	}
+
 	for key, values := range req.Headers {
 		shr.Header.Add(key, values)
 	}
+	//payload format 2.0 moves the cookie header into its own field
+	if len(req.Cookies) > 0 {
+		shr.Header.Set("Cookie", strings.Join(req.Cookies, "; "))
+	}
+	shr.Host = shr.Header.Get("Host")
+	shr.URL.Host = shr.Header.Get("Host")
+	//http apis include the stage in the path on execute-api hosts
+	shr.URL.Path = stageMapping.route(shr.URL.Path, shr.Host, req.RequestContext.Stage, true)
+	if shr.URL.RawPath != "" {
+		shr.URL.RawPath = stageMapping.route(shr.URL.RawPath, shr.Host, req.RequestContext.Stage, true)
+	}
+	shr = withStage(shr, req.RequestContext.Stage)
+	shr.URL.Scheme = forwardedProto(shr.Header)
+	shr.RemoteAddr = req.RequestContext.HTTP.SourceIP
+	if major, minor, ok := http.ParseHTTPVersion(req.RequestContext.HTTP.Protocol); ok {
+		shr.Proto, shr.ProtoMajor, shr.ProtoMinor = req.RequestContext.HTTP.Protocol, major, minor
+	}
 	return shr, err
 }
 
-func ToStdLibRequestV2(req events.APIGatewayV2HTTPRequest) (*http.Request, error) {
-	queryString := "?"
-	for key, value := range req.QueryStringParameters {
-		values := strings.Split(value, ",")
-		for _, v := range values {
-			if len(queryString) > 1 {
-				queryString += "&"
-			}
-			queryString += key + "=" + v
+//newStdLibRequest builds a request with a correctly escaped url
+//path is the decoded path, rawPath is the path as it was sent and is kept in URL.RawPath when it is a valid encoding of path
+func newStdLibRequest(method, path, rawPath, rawQuery string, body []byte) (*http.Request, error) {
+	if rawPath != "" {
+		unescaped, err := url.PathUnescape(rawPath)
+		if err != nil {
+			return nil, err
 		}
+		path = unescaped
 	}
-	shr, err := http.NewRequest(req.RequestContext.HTTP.Method, "https://host"+req.RawPath+queryString, bytes.NewBuffer([]byte(req.Body)))
+	if path == "" {
+		path = "/"
+	}
+	shr, err := http.NewRequest(method, "https://host/", bytes.NewBuffer(body))
 	if err != nil {
 		return shr, err
 	}
+	shr.URL.Path = path
+	shr.URL.RawPath = ""
+	if rawPath != "" && rawPath != shr.URL.EscapedPath() {
+		shr.URL.RawPath = rawPath
+	}
+	shr.URL.RawQuery = rawQuery
+	shr.RequestURI = ""
+	return shr, nil
+}
 
-	shr.Host = req.Headers["Host"]
-	shr.URL.Host = req.Headers["Host"]
-	// If we are on an aws domain, add the request Stage to the host
-	// (as it sneaks into the url path but is not considered in the "Path")
-	if re.MatchString(req.Headers["Host"]) {
-		shr.Host = shr.Host + "/" + req.RequestContext.Stage
-		shr.URL.Host = shr.URL.Host + "/" + req.RequestContext.Stage
+//forwardedProto returns the scheme the client used to connect to apigateway, defaulting to https
+func forwardedProto(header http.Header) string {
+	if proto := header.Get("CloudFront-Forwarded-Proto"); proto != "" {
+		return proto
 	}
-	shr.URL.Scheme = req.Headers["CloudFront-Forwarded-Proto"]
-	shr.RemoteAddr = req.RequestContext.HTTP.SourceIP
-	for key, values := range req.Headers {
-		shr.Header.Add(key, values)
+	if proto := header.Get("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	return "https"
+}
+
+//ToStdLibRequestALB converts an application load balancer target group event into the format expected by the std library
+//Query parameters are passed through as they were received, as the load balancer does not decode them
+func ToStdLibRequestALB(req events.ALBTargetGroupRequest) (*http.Request, error) {
+	multiValueQuery := req.MultiValueQueryStringParameters
+	if len(multiValueQuery) == 0 {
+		multiValueQuery = make(map[string][]string, len(req.QueryStringParameters))
+		for key, value := range req.QueryStringParameters {
+			multiValueQuery[key] = []string{value}
+		}
+	}
+	keys := make([]string, 0, len(multiValueQuery))
+	for key := range multiValueQuery {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var rawQuery []string
+	for _, key := range keys {
+		for _, value := range multiValueQuery[key] {
+			rawQuery = append(rawQuery, key+"="+value)
+		}
+	}
+	body, err := decodeBody(req.Body, req.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+	shr, err := newStdLibRequest(req.HTTPMethod, "", req.Path, strings.Join(rawQuery, "&"), body)
+	if err != nil {
+		return shr, err
+	}
+
+	for key, value := range req.Headers {
+		shr.Header.Add(key, value)
+	}
+	for key, values := range req.MultiValueHeaders {
+		for _, value := range values {
+			shr.Header.Add(key, value)
+		}
+	}
+	shr.Host = shr.Header.Get("Host")
+	shr.URL.Host = shr.Header.Get("Host")
+	shr.URL.Scheme = forwardedProto(shr.Header)
+	if forwardedFor := shr.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		shr.RemoteAddr = strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
 	}
 	return shr, err
 }
@@ -80,15 +191,15 @@ func ToStdLibRequestV2(req events.APIGatewayV2HTTPRequest) (*http.Request, error
 func ToApigRequest(req http.Request) (events.APIGatewayProxyRequest, error) {
 	apigReq := events.APIGatewayProxyRequest{}
 
-	parts := strings.Split(req.URL.Path, "/")
-	if parts[1] == "dev" || parts[1] == "prod" || parts[1] == "staging" {
-		apigReq.RequestContext.Stage = parts[1]
-		apigReq.Path = strings.Join(parts[1:], "/")
-	} else {
-		apigReq.Path = req.URL.Path
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
 	}
+	apigReq.RequestContext.Stage, apigReq.Path = stageMapping.split(host, req.URL.Path, true)
+	apigReq.RequestContext.Path = req.URL.Path
 	apigReq.HTTPMethod = req.Method
 	apigReq.Headers = make(map[string]string)
+	apigReq.MultiValueHeaders = make(map[string][]string)
 	apigReq.MultiValueQueryStringParameters = make(map[string][]string)
 	query := req.URL.Query()
 	for k, v := range query {
@@ -96,28 +207,174 @@ func ToApigRequest(req http.Request) (events.APIGatewayProxyRequest, error) {
 	}
 	for key, values := range req.Header {
 		apigReq.Headers[key] = strings.Join(values, ";")
+		apigReq.MultiValueHeaders[key] = values
 	}
-	apigReq.Headers["Host"] = req.Host
+	apigReq.Headers["Host"] = host
+	apigReq.MultiValueHeaders["Host"] = []string{host}
 	apigReq.Headers["CloudFront-Forwarded-Proto"] = req.URL.Scheme
+	apigReq.MultiValueHeaders["CloudFront-Forwarded-Proto"] = []string{req.URL.Scheme}
 	apigReq.RequestContext.Identity.SourceIP = req.RemoteAddr
 	if req.Body != nil {
 		body, err := ioutil.ReadAll(req.Body)
 		if err != nil {
 			return apigReq, err
 		}
-		apigReq.Body = string(body)
+		apigReq.Body, apigReq.IsBase64Encoded = encodeBody(req.Header, body)
+	}
+	return apigReq, nil
+}
+
+//ToApigRequestV2 converts a std library request into the payload format 2.0 event an http api would send for it
+//Repeated headers are joined with commas and the Cookie header is moved into the cookies field, as http apis do
+func ToApigRequestV2(req http.Request) (events.APIGatewayV2HTTPRequest, error) {
+	apigReq := events.APIGatewayV2HTTPRequest{
+		Version:        "2.0",
+		RouteKey:       "$default",
+		RawPath:        req.URL.EscapedPath(),
+		RawQueryString: req.URL.RawQuery,
+		Headers:        make(map[string]string, len(req.Header)+2),
+	}
+	for key, values := range req.URL.Query() {
+		if apigReq.QueryStringParameters == nil {
+			apigReq.QueryStringParameters = make(map[string]string)
+		}
+		apigReq.QueryStringParameters[key] = strings.Join(values, ",")
+	}
+	for key, values := range req.Header {
+		if strings.ToLower(key) == "cookie" {
+			for _, v := range values {
+				for _, cookie := range strings.Split(v, ";") {
+					if cookie = strings.TrimSpace(cookie); cookie != "" {
+						apigReq.Cookies = append(apigReq.Cookies, cookie)
+					}
+				}
+			}
+			continue
+		}
+		apigReq.Headers[strings.ToLower(key)] = strings.Join(values, ",")
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	apigReq.Headers["host"] = host
+	if _, ok := apigReq.Headers["x-forwarded-proto"]; !ok && req.URL.Scheme != "" {
+		apigReq.Headers["x-forwarded-proto"] = req.URL.Scheme
+	}
+	sourceIP := req.RemoteAddr
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		sourceIP = ip
+	}
+	stage, _ := stageMapping.split(host, req.URL.Path, false)
+	if stage == "" {
+		stage = "$default"
+	}
+	now := time.Now()
+	apigReq.RequestContext = events.APIGatewayV2HTTPRequestContext{
+		RouteKey:     "$default",
+		Stage:        stage,
+		DomainName:   host,
+		DomainPrefix: strings.Split(host, ".")[0],
+		Time:         now.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+		TimeEpoch:    now.UnixNano() / int64(time.Millisecond),
+		HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+			Method:    req.Method,
+			Path:      req.URL.Path,
+			Protocol:  req.Proto,
+			SourceIP:  sourceIP,
+			UserAgent: req.UserAgent(),
+		},
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return apigReq, err
+		}
+		apigReq.Body, apigReq.IsBase64Encoded = encodeBody(req.Header, body)
 	}
 	return apigReq, nil
 }
 
 func ToStdLibResponse(resp events.APIGatewayProxyResponse) http.Response {
+	body, err := decodeBody(resp.Body, resp.IsBase64Encoded)
+	if err != nil {
+		logger.Println(err.Error())
+		body = []byte(resp.Body)
+	}
 	shr := http.Response{
 		StatusCode: resp.StatusCode,
-		Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(resp.Body))),
+		Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
 		Header:     http.Header{},
 	}
 	for k, v := range resp.Headers {
 		shr.Header.Add(k, v)
 	}
+	for k, values := range resp.MultiValueHeaders {
+		shr.Header.Del(k)
+		for _, v := range values {
+			shr.Header.Add(k, v)
+		}
+	}
 	return shr
 }
+
+//ToStdLibResponseV2 converts a payload format 2.0 response into the format used by the std library
+//The cookies field is returned as Set-Cookie headers
+func ToStdLibResponseV2(resp events.APIGatewayV2HTTPResponse) http.Response {
+	body, err := decodeBody(resp.Body, resp.IsBase64Encoded)
+	if err != nil {
+		logger.Println(err.Error())
+		body = []byte(resp.Body)
+	}
+	shr := http.Response{
+		StatusCode: resp.StatusCode,
+		Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+		Header:     http.Header{},
+	}
+	for k, v := range resp.Headers {
+		shr.Header.Add(k, v)
+	}
+	for k, values := range resp.MultiValueHeaders {
+		shr.Header.Del(k)
+		for _, v := range values {
+			shr.Header.Add(k, v)
+		}
+	}
+	for _, cookie := range resp.Cookies {
+		shr.Header.Add("Set-Cookie", cookie)
+	}
+	return shr
+}
+
+//ToApigResponse converts a std library response into the response apigateway expects, as ResponseWriter would
+//The response body is read and closed
+func ToApigResponse(resp *http.Response) (events.APIGatewayProxyResponse, error) {
+	rw := ResponseWriter{}
+	if err := copyResponse(&rw, resp); err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	return rw.GetResponse()
+}
+
+//ToApigResponseV2 converts a std library response into the payload format 2.0 response, as ResponseWriterV2 would
+//The response body is read and closed
+func ToApigResponseV2(resp *http.Response) (events.APIGatewayV2HTTPResponse, error) {
+	rw := ResponseWriterV2{}
+	if err := copyResponse(&rw, resp); err != nil {
+		return events.APIGatewayV2HTTPResponse{}, err
+	}
+	return rw.GetResponse()
+}
+
+func copyResponse(rw http.ResponseWriter, resp *http.Response) error {
+	for key, values := range resp.Header {
+		rw.Header()[key] = append([]string(nil), values...)
+	}
+	rw.WriteHeader(resp.StatusCode)
+	if resp.Body == nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	_, err := io.Copy(rw, resp.Body)
+	return err
+}
@@ -0,0 +1,282 @@
+package apig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToStdLibRequestEscaping(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		query       map[string][]string
+		escapedPath string
+	}{
+		{"plain", "/users/1", map[string][]string{"page": {"2"}}, "/users/1"},
+		{"reserved characters in values", "/search", map[string][]string{"q": {"a&b=c", "50% off"}, "sort": {"name asc"}}, "/search"},
+		{"unicode", "/café/ünïcode", map[string][]string{"name": {"Zoë", "日本"}}, "/caf%C3%A9/%C3%BCn%C3%AFcode"},
+		{"spaces and question marks in the path", "/a b/c?d", map[string][]string{"x": {""}}, "/a%20b/c%3Fd"},
+		{"reserved characters in keys", "/", map[string][]string{"a=b&c": {"d"}, "+": {"+"}}, "/"},
+		{"no query", "/empty", nil, "/empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.APIGatewayProxyRequest{
+				HTTPMethod:                      http.MethodGet,
+				Path:                            tt.path,
+				MultiValueQueryStringParameters: tt.query,
+			}
+			stReq, err := apig.ToStdLibRequest(req)
+			require.NoError(t, err)
+			require.Equal(t, tt.path, stReq.URL.Path)
+			require.Equal(t, tt.escapedPath, stReq.URL.EscapedPath())
+			for key, values := range tt.query {
+				require.Equal(t, values, stReq.URL.Query()[key])
+			}
+
+			parsed, err := url.Parse(stReq.URL.String())
+			require.NoError(t, err)
+			require.Equal(t, tt.path, parsed.Path)
+
+			apigReq, err := apig.ToApigRequest(*stReq)
+			require.NoError(t, err)
+			require.Equal(t, tt.path, apigReq.Path)
+			if len(tt.query) > 0 {
+				require.Equal(t, tt.query, apigReq.MultiValueQueryStringParameters)
+			}
+		})
+	}
+}
+
+func TestToStdLibRequestV2Escaping(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawPath  string
+		rawQuery string
+		path     string
+		query    url.Values
+	}{
+		{"plain", "/users/1", "page=2", "/users/1", url.Values{"page": {"2"}}},
+		{"encoded slash kept", "/files/a%2Fb.txt", "", "/files/a/b.txt", url.Values{}},
+		{"reserved characters in values", "/search", "q=a%26b%3Dc&q=50%25+off&sort=name%20asc", "/search", url.Values{"q": {"a&b=c", "50% off"}, "sort": {"name asc"}}},
+		{"unicode", "/caf%C3%A9", "name=Zo%C3%AB", "/café", url.Values{"name": {"Zoë"}}},
+		{"order preserved", "/", "z=1&a=2&z=3", "/", url.Values{"z": {"1", "3"}, "a": {"2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.APIGatewayV2HTTPRequest{
+				RawPath:        tt.rawPath,
+				RawQueryString: tt.rawQuery,
+			}
+			req.RequestContext.HTTP.Method = http.MethodGet
+			stReq, err := apig.ToStdLibRequestV2(req)
+			require.NoError(t, err)
+			require.Equal(t, tt.path, stReq.URL.Path)
+			require.Equal(t, tt.rawPath, stReq.URL.EscapedPath())
+			require.Equal(t, tt.rawQuery, stReq.URL.RawQuery)
+			require.Equal(t, tt.query, stReq.URL.Query())
+		})
+	}
+}
+
+func TestToStdLibRequestV2QueryStringParametersFallback(t *testing.T) {
+	req := events.APIGatewayV2HTTPRequest{
+		RawPath:               "/",
+		QueryStringParameters: map[string]string{"petType": "cat,dog", "q": "a b&c"},
+	}
+	req.RequestContext.HTTP.Method = http.MethodGet
+	stReq, err := apig.ToStdLibRequestV2(req)
+	require.NoError(t, err)
+	require.Equal(t, []string{"cat", "dog"}, stReq.URL.Query()["petType"])
+	require.Equal(t, "a b&c", stReq.URL.Query().Get("q"))
+}
+
+//v2Message is a random request or response that payload format 2.0 can represent without loss
+//Each header has a single value, as repeated headers are joined with commas
+type v2Message struct {
+	Method   string
+	Segments []string
+	Query    url.Values
+	Header   http.Header
+	Cookies  []*http.Cookie
+	Body     []byte
+	Binary   bool
+	Status   int
+}
+
+const randomText = "abcXYZ019 -._~%/?#&=+;,:@!$'()*zoë日本🙂"
+
+func randomString(rand *rand.Rand, alphabet string, max int) string {
+	runes := []rune(alphabet)
+	s := make([]rune, rand.Intn(max+1))
+	for i := range s {
+		s[i] = runes[rand.Intn(len(runes))]
+	}
+	return string(s)
+}
+
+//Generate implements quick.Generator
+func (v2Message) Generate(rand *rand.Rand, size int) reflect.Value {
+	const token = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	m := v2Message{
+		Method: []string{"GET", "POST", "PUT", "PATCH", "DELETE"}[rand.Intn(5)],
+		Query:  url.Values{},
+		Header: http.Header{},
+		Binary: rand.Intn(2) == 0,
+		Status: 200 + rand.Intn(400),
+	}
+	for i := rand.Intn(4); i >= 0; i-- {
+		m.Segments = append(m.Segments, randomString(rand, randomText, size))
+	}
+	for i := rand.Intn(4); i > 0; i-- {
+		key := randomString(rand, randomText, size)
+		for j := rand.Intn(3); j >= 0; j-- {
+			m.Query.Add(key, randomString(rand, randomText, size))
+		}
+	}
+	for i := rand.Intn(4); i > 0; i-- {
+		m.Header.Set("X-"+randomString(rand, token, size)+"a", randomString(rand, " !\"#$%&'()*+,-./:;<=>?@[]^_`{|}~"+token, size))
+	}
+	for i := rand.Intn(3); i > 0; i-- {
+		m.Cookies = append(m.Cookies, &http.Cookie{Name: "c" + randomString(rand, token, size), Value: randomString(rand, token, size)})
+	}
+	if m.Binary {
+		m.Body = make([]byte, rand.Intn(size*4+1))
+		rand.Read(m.Body)
+		m.Header.Set("Content-Type", "application/octet-stream")
+	} else {
+		m.Body = []byte(randomString(rand, randomText+"\n\t\"{}", size*4))
+		m.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	return reflect.ValueOf(m)
+}
+
+func (m v2Message) request() *http.Request {
+	r, err := http.NewRequest(m.Method, "https://api.example.com/", bytes.NewReader(m.Body))
+	if err != nil {
+		panic(err)
+	}
+	escaped := make([]string, len(m.Segments))
+	for i, segment := range m.Segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	r.URL.Path = "/" + strings.Join(m.Segments, "/")
+	r.URL.RawPath = "/" + strings.Join(escaped, "/")
+	r.URL.RawQuery = m.Query.Encode()
+	r.Header = m.Header.Clone()
+	for _, c := range m.Cookies {
+		r.AddCookie(c)
+	}
+	r.RemoteAddr = "203.0.113.7"
+	return r
+}
+
+func jsonRoundTrip(t *testing.T, in interface{}, out interface{}) {
+	raw, err := json.Marshal(in)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, out))
+}
+
+func TestV2RequestRoundTrip(t *testing.T) {
+	apig.SetBinaryMediaTypes("application/octet-stream")
+	defer apig.SetBinaryMediaTypes()
+	err := quick.Check(func(m v2Message) bool {
+		original := m.request()
+		event, err := apig.ToApigRequestV2(*m.request())
+		require.NoError(t, err)
+		require.Equal(t, m.Binary, event.IsBase64Encoded)
+		var decoded events.APIGatewayV2HTTPRequest
+		jsonRoundTrip(t, event, &decoded)
+
+		shr, err := apig.ToStdLibRequestV2(decoded)
+		require.NoError(t, err)
+		require.Equal(t, original.Method, shr.Method)
+		require.Equal(t, original.URL.Path, shr.URL.Path)
+		require.Equal(t, original.URL.EscapedPath(), shr.URL.EscapedPath())
+		require.Equal(t, original.URL.RawQuery, shr.URL.RawQuery)
+		require.Equal(t, original.Host, shr.Host)
+		require.Equal(t, original.RemoteAddr, shr.RemoteAddr)
+		require.Equal(t, original.Proto, shr.Proto)
+		expectedHeader := original.Header.Clone()
+		expectedHeader.Set("Host", original.Host)
+		expectedHeader.Set("X-Forwarded-Proto", "https")
+		require.Equal(t, expectedHeader, shr.Header)
+		body, err := ioutil.ReadAll(shr.Body)
+		require.NoError(t, err)
+		require.Equal(t, m.Body, body)
+		shr.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		//and the event survives the trip the other way
+		reconverted, err := apig.ToApigRequestV2(*shr)
+		require.NoError(t, err)
+		reconverted.RequestContext.Time, reconverted.RequestContext.TimeEpoch = event.RequestContext.Time, event.RequestContext.TimeEpoch
+		require.Equal(t, event, reconverted)
+		return true
+	}, nil)
+	require.NoError(t, err)
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	apig.SetBinaryMediaTypes("application/octet-stream")
+	defer apig.SetBinaryMediaTypes()
+	err := quick.Check(func(m v2Message) bool {
+		write := func(rw http.ResponseWriter) {
+			for key, values := range m.Header {
+				rw.Header()[key] = values
+			}
+			for _, c := range m.Cookies {
+				http.SetCookie(rw, c)
+			}
+			rw.WriteHeader(m.Status)
+			rw.Write(m.Body)
+		}
+		expectedHeader := m.Header.Clone()
+		for _, c := range m.Cookies {
+			expectedHeader.Add("Set-Cookie", c.String())
+		}
+
+		v2Writer := apig.ResponseWriterV2{}
+		write(&v2Writer)
+		v2Resp, err := v2Writer.GetResponse()
+		require.NoError(t, err)
+		var decodedV2 events.APIGatewayV2HTTPResponse
+		jsonRoundTrip(t, v2Resp, &decodedV2)
+		shr := apig.ToStdLibResponseV2(decodedV2)
+		require.Equal(t, m.Status, shr.StatusCode)
+		require.Equal(t, expectedHeader, shr.Header)
+		reconvertedV2, err := apig.ToApigResponseV2(&shr)
+		require.NoError(t, err)
+		require.Equal(t, decodedV2, reconvertedV2)
+
+		v1Writer := apig.ResponseWriter{}
+		write(&v1Writer)
+		v1Resp, err := v1Writer.GetResponse()
+		require.NoError(t, err)
+		var decodedV1 events.APIGatewayProxyResponse
+		jsonRoundTrip(t, v1Resp, &decodedV1)
+		shr = apig.ToStdLibResponse(decodedV1)
+		require.Equal(t, m.Status, shr.StatusCode)
+		require.Equal(t, expectedHeader, shr.Header)
+		body, err := ioutil.ReadAll(shr.Body)
+		require.NoError(t, err)
+		require.Equal(t, m.Body, body)
+		shr.Body = ioutil.NopCloser(bytes.NewReader(body))
+		reconvertedV1, err := apig.ToApigResponse(&shr)
+		require.NoError(t, err)
+		require.Equal(t, decodedV1, reconvertedV1)
+		return true
+	}, nil)
+	require.NoError(t, err)
+}
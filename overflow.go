@@ -0,0 +1,264 @@
+package apig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//OverflowStrategy replaces a response that is too large for lambda to return
+//It is given the request and the oversized response, and returns the response to send instead
+//The replacement is checked again and rejected with a 500 if it is still too large
+type OverflowStrategy func(r *http.Request, resp *http.Response) (*http.Response, error)
+
+var overflowStrategy = RejectOverflow(http.StatusInternalServerError)
+
+//SetOverflowStrategy sets how Serve and ServeV2 handle responses larger than the lambda payload limit, nil restores the default of RejectOverflow(500)
+func SetOverflowStrategy(strategy OverflowStrategy) {
+	if strategy == nil {
+		strategy = RejectOverflow(http.StatusInternalServerError)
+	}
+	overflowStrategy = strategy
+}
+
+//RejectOverflow replaces oversized responses with a problem+json error with status, such as 413 or 500, and notifies admins
+func RejectOverflow(status int) OverflowStrategy {
+	return func(r *http.Request, resp *http.Response) (*http.Response, error) {
+		msg := fmt.Sprintf("Response body too large: %d", resp.ContentLength)
+		logger.Println(msg + " for " + r.Method + " " + r.URL.Path)
+		logger.NotifyAdmin(msg, map[string]interface{}{
+			"method":    r.Method,
+			"path":      r.URL.Path,
+			"requestId": requestID(r),
+			"status":    resp.StatusCode,
+		})
+		return problemResponse(Problem{
+			Type:   "about:blank",
+			Title:  http.StatusText(status),
+			Status: status,
+			Detail: "Response body too large",
+		}), nil
+	}
+}
+
+//CompressOverflow gzips oversized responses for clients that accept it, using next when the client doesn't or the response is still too large
+//Compressed responses are base64 encoded, rest apis need a binaryMediaTypes entry matching the Accept header of the request for apigateway to decode them
+func CompressOverflow(next OverflowStrategy) OverflowStrategy {
+	return func(r *http.Request, resp *http.Response) (*http.Response, error) {
+		if !acceptsGzip(r) || resp.Header.Get("Content-Encoding") != "" {
+			return next(r, resp)
+		}
+		body, err := readResponseBody(resp)
+		if err != nil {
+			return nil, err
+		}
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err = gz.Write(body); err != nil {
+			return nil, err
+		}
+		if err = gz.Close(); err != nil {
+			return nil, err
+		}
+		header := resp.Header.Clone()
+		header.Set("Content-Encoding", "gzip")
+		header.Add("Vary", "Accept-Encoding")
+		header.Del("Content-Length")
+		if estimatedPayloadSize(header, compressed.Len(), true) > awsLambdaMaxBodySize {
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			return next(r, resp)
+		}
+		return &http.Response{
+			StatusCode:    resp.StatusCode,
+			Header:        header,
+			Body:          ioutil.NopCloser(&compressed),
+			ContentLength: int64(compressed.Len()),
+		}, nil
+	}
+}
+
+//BlobStore stores response bodies that are too large to return from lambda
+type BlobStore interface {
+	//Put stores body under key and returns a url the client can fetch it from, header has the Content-Type of the body
+	Put(ctx context.Context, key string, header http.Header, body []byte) (string, error)
+}
+
+//OffloadOverflow puts oversized response bodies in store and redirects the client to them with a 303 See Other
+//Only successful responses are offloaded, anything else is passed to next
+func OffloadOverflow(store BlobStore, next OverflowStrategy) OverflowStrategy {
+	return func(r *http.Request, resp *http.Response) (*http.Response, error) {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return next(r, resp)
+		}
+		body, err := readResponseBody(resp)
+		if err != nil {
+			return nil, err
+		}
+		key := requestID(r)
+		if key == "" {
+			key = newRequestID()
+		}
+		location, err := store.Put(r.Context(), key, resp.Header.Clone(), body)
+		if err != nil {
+			logger.Println(err.Error())
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			return next(r, resp)
+		}
+		return &http.Response{
+			StatusCode: http.StatusSeeOther,
+			Header:     http.Header{"Location": {location}},
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}, nil
+	}
+}
+
+//FileBlobStore is a BlobStore that writes bodies to a directory, it is meant for tests and local development
+//It serves the stored bodies as an http.Handler, so BaseURL can point at a server running it
+type FileBlobStore struct {
+	Dir     string
+	BaseURL string
+}
+
+//NewFileBlobStore returns a store that writes to dir and returns urls under baseURL
+func NewFileBlobStore(dir, baseURL string) *FileBlobStore {
+	return &FileBlobStore{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+//Put writes body to a file named key in the store's directory
+func (s *FileBlobStore) Put(ctx context.Context, key string, header http.Header, body []byte) (string, error) {
+	name := filepath.Base(filepath.Clean("/" + key))
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.Dir, name), body, 0644); err != nil {
+		return "", err
+	}
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		if err := ioutil.WriteFile(filepath.Join(s.Dir, name+".content-type"), []byte(contentType), 0644); err != nil {
+			return "", err
+		}
+	}
+	return s.BaseURL + "/" + name, nil
+}
+
+//ServeHTTP serves a stored body
+func (s *FileBlobStore) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(filepath.Clean("/" + r.URL.Path))
+	if strings.HasSuffix(name, ".content-type") {
+		http.NotFound(rw, r)
+		return
+	}
+	if contentType, err := ioutil.ReadFile(filepath.Join(s.Dir, name+".content-type")); err == nil {
+		rw.Header().Set("Content-Type", string(contentType))
+	}
+	http.ServeFile(rw, r, filepath.Join(s.Dir, name))
+}
+
+//checkOverflow returns the response to send in place of resp, whose payload of size bytes is over the lambda limit
+func checkOverflow(r *http.Request, resp http.Response, size int) *http.Response {
+	resp.ContentLength = int64(size)
+	replacement, err := overflowStrategy(r, &resp)
+	if err != nil || replacement == nil {
+		if err != nil {
+			logger.Println(err.Error())
+		}
+		replacement, _ = RejectOverflow(http.StatusInternalServerError)(r, &resp)
+	}
+	//the replacement still needs the CORS headers for the browser to read it
+	for key, values := range resp.Header {
+		if _, ok := replacement.Header[key]; !ok && strings.HasPrefix(key, "Access-Control-") {
+			replacement.Header[key] = values
+		}
+	}
+	return replacement
+}
+
+//rejectOversized replaces a response from the overflow strategy that is still too large
+func rejectOversized(r *http.Request, resp *http.Response) *http.Response {
+	replacement, _ := RejectOverflow(http.StatusInternalServerError)(r, resp)
+	return replacement
+}
+
+//payloadSize is the size of a response once lambda has encoded it, including headers and base64 encoding
+func payloadSize(resp interface{}) int {
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return 0
+	}
+	return len(raw)
+}
+
+//responseSize is the payload size of a response whose body of bodyLen bytes was counted as it was written
+//It is estimated from the headers and body length, and only marshalled when json escaping could take it over the limit
+func responseSize(resp interface{}, header http.Header, bodyLen int, base64Encoded bool) int {
+	if maxPayloadSize(header, bodyLen, base64Encoded) > awsLambdaMaxBodySize {
+		return payloadSize(resp)
+	}
+	return estimatedPayloadSize(header, bodyLen, base64Encoded)
+}
+
+//maxPayloadSize is the largest payloadSize can be, json escaping grows a byte of text to at most 6 bytes such as < to \u003c
+func maxPayloadSize(header http.Header, bodyLen int, base64Encoded bool) int {
+	size := bodyLen * 6
+	if base64Encoded {
+		size = (bodyLen + 2) / 3 * 4
+	}
+	for key, values := range header {
+		for _, v := range values {
+			size += (len(key)+len(v))*6 + 6
+		}
+	}
+	return size + 128
+}
+
+//estimatedPayloadSize approximates payloadSize for a response that hasn't been converted yet
+func estimatedPayloadSize(header http.Header, bodyLen int, base64Encoded bool) int {
+	size := bodyLen
+	if base64Encoded {
+		size = (bodyLen + 2) / 3 * 4
+	}
+	for key, values := range header {
+		for _, v := range values {
+			size += len(key) + len(v) + 6
+		}
+	}
+	//the status code, field names and punctuation of the response
+	return size + 128
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.Split(accept, ";")[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	if resp.Body == nil {
+		return nil, nil
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func problemResponse(problem Problem) *http.Response {
+	body, err := json.Marshal(problem)
+	if err != nil {
+		logger.Println(err.Error())
+	}
+	return &http.Response{
+		StatusCode:    problem.Status,
+		Header:        http.Header{"Content-Type": {problemContentType}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
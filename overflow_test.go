@@ -0,0 +1,112 @@
+package apig_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+	"github.com/stretchr/testify/require"
+)
+
+func largeBodyHandler(contentType string, size int) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", contentType)
+		rw.Write([]byte(strings.Repeat("a", size)))
+	})
+}
+
+func TestServeRejectsOversizedResponses(t *testing.T) {
+	tNot := testNotifierLogger()
+	apig.SetCORSConfig(apig.DefaultCORSConfig())
+	defer apig.ResetCORSConfig()
+	apigtest.Serve(t, largeBodyHandler("text/plain", 6*1000*1000+1), apigtest.NewV1Request("GET", "/large")).
+		AssertStatus(http.StatusInternalServerError).
+		AssertHeader("Content-Type", "application/problem+json").
+		AssertHeader("Access-Control-Allow-Origin", "*")
+	require.True(t, tNot.GotNotification("Response body too large"))
+
+	//5 MB fits until it is base64 encoded
+	apig.SetBinaryMediaTypes("application/octet-stream")
+	defer apig.SetBinaryMediaTypes()
+	apigtest.ServeV2(t, largeBodyHandler("application/octet-stream", 5*1000*1000), apigtest.NewV2Request("GET", "/binary")).
+		AssertStatus(http.StatusInternalServerError)
+	apigtest.ServeV2(t, largeBodyHandler("text/plain", 5*1000*1000), apigtest.NewV2Request("GET", "/text")).
+		AssertStatus(http.StatusOK)
+
+	//headers count towards the limit too
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-Large", strings.Repeat("b", 1000*1000))
+		rw.Write([]byte(strings.Repeat("a", 5*1000*1000+500*1000)))
+	})
+	apigtest.Serve(t, handler, apigtest.NewV1Request("GET", "/headers")).
+		AssertStatus(http.StatusInternalServerError)
+
+	//and so does json escaping, which encodes < as \u003c
+	escaped := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/html")
+		rw.Write([]byte(strings.Repeat("<", 1500*1000)))
+	})
+	apigtest.ServeV2(t, escaped, apigtest.NewV2Request("GET", "/escaped")).
+		AssertStatus(http.StatusInternalServerError)
+}
+
+func TestRejectOverflowStatus(t *testing.T) {
+	testNotifierLogger()
+	defer apig.SetOverflowStrategy(nil)
+	apig.SetOverflowStrategy(apig.RejectOverflow(http.StatusRequestEntityTooLarge))
+	apigtest.ServeV2(t, largeBodyHandler("text/plain", 7*1000*1000), apigtest.NewV2Request("GET", "/large")).
+		AssertStatus(http.StatusRequestEntityTooLarge).
+		AssertJSON(`{"type":"about:blank","title":"Request Entity Too Large","status":413,"detail":"Response body too large"}`)
+}
+
+func TestCompressOverflow(t *testing.T) {
+	testNotifierLogger()
+	defer apig.SetOverflowStrategy(nil)
+	apig.SetOverflowStrategy(apig.CompressOverflow(apig.RejectOverflow(http.StatusRequestEntityTooLarge)))
+
+	resp, err := apig.ServeV2(apigtest.NewV2Request("GET", "/large").WithHeader("Accept-Encoding", "br, gzip;q=0.9").Build(), largeBodyHandler("text/plain", 7*1000*1000))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "gzip", resp.Headers["Content-Encoding"])
+	require.Equal(t, "text/plain", resp.Headers["Content-Type"])
+	require.True(t, resp.IsBase64Encoded)
+	shr := apig.ToStdLibResponseV2(resp)
+	gz, err := gzip.NewReader(shr.Body)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, 7*1000*1000, len(body))
+
+	apigtest.ServeV2(t, largeBodyHandler("text/plain", 7*1000*1000), apigtest.NewV2Request("GET", "/large")).
+		AssertStatus(http.StatusRequestEntityTooLarge)
+}
+
+func TestOffloadOverflow(t *testing.T) {
+	testNotifierLogger()
+	store := apig.NewFileBlobStore(t.TempDir(), "")
+	server := httptest.NewServer(store)
+	defer server.Close()
+	store.BaseURL = server.URL
+	defer apig.SetOverflowStrategy(nil)
+	apig.SetOverflowStrategy(apig.OffloadOverflow(store, apig.RejectOverflow(http.StatusInternalServerError)))
+
+	large := apigtest.Serve(t, largeBodyHandler("text/csv", 7*1000*1000), apigtest.NewV1Request("GET", "/export")).
+		AssertStatus(http.StatusSeeOther)
+	location := large.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, server.URL+"/"))
+
+	resp, err := http.Get(location)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(bytes.Repeat([]byte("a"), 7*1000*1000), body))
+}
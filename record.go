@@ -0,0 +1,319 @@
+package apig
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+//Recording is a line of a recording written by Record and read by Replay
+type Recording struct {
+	Time     time.Time       `json:"time"`
+	Event    json.RawMessage `json:"event"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	//EventRedacted is set when headers, cookies or the body of the event were redacted, see ReplayOptions for how Replay treats these events
+	EventRedacted bool `json:"eventRedacted,omitempty"`
+}
+
+//RecordOptions controls what Record writes
+//Redacting an event's headers, such as Authorization or Cookie, or its body means the handler sees REDACTED when it is replayed
+//Such events are marked as redacted in the recording, so Replay can put back the values with ReplayOptions.Restore or skip them
+type RecordOptions struct {
+	//RedactHeaders are header names, matched case insensitively, whose values are replaced in events and responses
+	//Redacting "Cookie" also redacts the cookies of payload format 2.0 events, and "Set-Cookie" the cookies of their responses
+	RedactHeaders []string
+	//RedactBody is called with each event and response body and the result is recorded instead, bodies are recorded as is when it is nil
+	RedactBody func(body string) string
+}
+
+//Record wraps a lambda handler, appending each event and the response it produced to sink as a line of json
+//Recordings can be fed back through a handler with Replay to check for regressions
+func Record(handler lambdaContextHandlerFunc, sink io.Writer, opts RecordOptions) lambdaContextHandlerFunc {
+	var mu sync.Mutex
+	return func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		resp, err := handler(ctx, event)
+		recording := Recording{Time: time.Now().UTC()}
+		recording.Event, recording.EventRedacted = opts.redact(event, "Cookie")
+		if err != nil {
+			recording.Error = err.Error()
+		} else if raw, jsonerr := json.Marshal(resp); jsonerr == nil {
+			recording.Response, _ = opts.redact(raw, "Set-Cookie")
+		} else {
+			logger.Println(jsonerr.Error())
+		}
+		line, jsonerr := json.Marshal(recording)
+		if jsonerr != nil {
+			logger.Println(jsonerr.Error())
+			return resp, err
+		}
+		mu.Lock()
+		_, writeErr := sink.Write(append(line, '\n'))
+		mu.Unlock()
+		if writeErr != nil {
+			logger.Println(writeErr.Error())
+		}
+		return resp, err
+	}
+}
+
+//redact applies the redaction options to an event or response and reports whether anything was redacted
+//cookieHeader is the header the cookies field stands for, Cookie in events and Set-Cookie in responses
+//Anything that isn't a json object is returned unchanged
+func (opts RecordOptions) redact(raw json.RawMessage, cookieHeader string) (json.RawMessage, bool) {
+	if len(opts.RedactHeaders) == 0 && opts.RedactBody == nil {
+		return raw, false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw, false
+	}
+	changed := false
+	redactHeader := func(key string) bool {
+		for _, h := range opts.RedactHeaders {
+			if strings.EqualFold(h, key) {
+				return true
+			}
+		}
+		return false
+	}
+	if headers, ok := fields["headers"].(map[string]interface{}); ok {
+		for key := range headers {
+			if redactHeader(key) {
+				headers[key] = redacted
+				changed = true
+			}
+		}
+	}
+	if headers, ok := fields["multiValueHeaders"].(map[string]interface{}); ok {
+		for key, values := range headers {
+			if redactHeader(key) {
+				if values, ok := values.([]interface{}); ok {
+					for i := range values {
+						values[i] = redacted
+					}
+					changed = changed || len(values) > 0
+				}
+			}
+		}
+	}
+	if cookies, ok := fields["cookies"].([]interface{}); ok && redactHeader(cookieHeader) {
+		for i := range cookies {
+			cookies[i] = redacted
+		}
+		changed = changed || len(cookies) > 0
+	}
+	if body, ok := fields["body"].(string); ok && opts.RedactBody != nil {
+		fields["body"] = opts.RedactBody(body)
+		if fields["body"] != body {
+			//a redacted body is no longer base64
+			fields["isBase64Encoded"] = false
+			changed = true
+		}
+	}
+	redactedRaw, err := json.Marshal(fields)
+	if err != nil {
+		return raw, false
+	}
+	return redactedRaw, changed
+}
+
+//ReplayOptions controls how Replay compares responses
+type ReplayOptions struct {
+	//IgnoreHeaders are header names, matched case insensitively, that are expected to differ between runs such as Date
+	IgnoreHeaders []string
+	//Restore is called with each event recorded with redacted inputs before it is replayed, to put back or substitute the redacted values
+	//RestoreHeaders substitutes headers such as the Authorization of a test user, events are replayed with the redacted values when it is nil
+	Restore func(event json.RawMessage) (json.RawMessage, error)
+	//SkipRedacted skips events recorded with redacted inputs instead of replaying them
+	SkipRedacted bool
+}
+
+//RestoreHeaders returns a ReplayOptions.Restore func that replaces redacted values of the headers with the given values
+//A Cookie value such as "session=abc; theme=dark" also replaces the redacted cookies of payload format 2.0 events
+func RestoreHeaders(values map[string]string) func(event json.RawMessage) (json.RawMessage, error) {
+	return func(event json.RawMessage) (json.RawMessage, error) {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(event, &fields); err != nil {
+			return nil, err
+		}
+		for name, value := range values {
+			if headers, ok := fields["headers"].(map[string]interface{}); ok {
+				for key := range headers {
+					if strings.EqualFold(key, name) && headers[key] == redacted {
+						headers[key] = value
+					}
+				}
+			}
+			if headers, ok := fields["multiValueHeaders"].(map[string]interface{}); ok {
+				for key, keyValues := range headers {
+					if keyValues, ok := keyValues.([]interface{}); ok && strings.EqualFold(key, name) {
+						for i := range keyValues {
+							if keyValues[i] == redacted {
+								keyValues[i] = value
+							}
+						}
+					}
+				}
+			}
+			if cookies, ok := fields["cookies"].([]interface{}); ok && strings.EqualFold(name, "Cookie") && len(cookies) > 0 && cookies[0] == redacted {
+				fields["cookies"] = strings.Split(value, "; ")
+			}
+		}
+		return json.Marshal(fields)
+	}
+}
+
+//ReplayResult compares a recorded response with the response produced on replay
+type ReplayResult struct {
+	//Line is the line of the recording, starting at 1
+	Line     int
+	Event    json.RawMessage
+	Recorded json.RawMessage
+	Replayed json.RawMessage
+	//Diffs describes each difference between the responses, it is empty when they match
+	Diffs []string
+	//Skipped is set when the event was recorded with redacted inputs and ReplayOptions.SkipRedacted is set
+	Skipped bool
+}
+
+//Replay feeds each recorded event through the handler as LambdaHandlerWithContext would and compares the response with the recorded one
+//Events recorded with redacted headers, cookies or bodies are restored or skipped as set in opts, and headers and bodies redacted in the recorded response are not compared
+func Replay(ctx context.Context, recordings io.Reader, handler http.Handler, opts ReplayOptions) ([]ReplayResult, error) {
+	return ReplayLambda(ctx, recordings, LambdaHandlerWithContext(handler, nil), opts)
+}
+
+//ReplayLambda is Replay for a lambda handler func, such as one that invokes a handler binary through the runtime api
+func ReplayLambda(ctx context.Context, recordings io.Reader, handler lambdaContextHandlerFunc, opts ReplayOptions) ([]ReplayResult, error) {
+	var results []ReplayResult
+	scanner := bufio.NewScanner(recordings)
+	scanner.Buffer(make([]byte, 64*1024), 2*awsLambdaMaxBodySize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var recording Recording
+		if err := json.Unmarshal(scanner.Bytes(), &recording); err != nil {
+			return results, fmt.Errorf("Unable to parse recording on line %d: %v", line, err)
+		}
+		result := ReplayResult{Line: line, Event: recording.Event, Recorded: recording.Response}
+		event := recording.Event
+		if recording.EventRedacted && opts.SkipRedacted {
+			result.Skipped = true
+			results = append(results, result)
+			continue
+		}
+		if recording.EventRedacted && opts.Restore != nil {
+			restored, err := opts.Restore(event)
+			if err != nil {
+				return results, fmt.Errorf("Unable to restore the event on line %d: %v", line, err)
+			}
+			event = restored
+		}
+		resp, err := handler(ctx, event)
+		switch {
+		case err != nil && recording.Error == "":
+			result.Diffs = append(result.Diffs, "error: recorded none, replayed "+err.Error())
+		case err == nil && recording.Error != "":
+			result.Diffs = append(result.Diffs, "error: recorded "+recording.Error+", replayed none")
+		case err != nil && err.Error() != recording.Error:
+			result.Diffs = append(result.Diffs, "error: recorded "+recording.Error+", replayed "+err.Error())
+		}
+		if err == nil {
+			result.Replayed, err = json.Marshal(resp)
+			if err != nil {
+				return results, err
+			}
+			if recording.Error == "" {
+				result.Diffs = append(result.Diffs, diffResponses(recording.Response, result.Replayed, opts)...)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, scanner.Err()
+}
+
+//diffResponses compares two apigateway responses of either payload format
+func diffResponses(recordedRaw, replayedRaw json.RawMessage, opts ReplayOptions) []string {
+	var recorded, replayed localResponse
+	if json.Unmarshal(recordedRaw, &recorded) != nil || json.Unmarshal(replayedRaw, &replayed) != nil {
+		if !jsonEqual(recordedRaw, replayedRaw) {
+			return []string{fmt.Sprintf("response: recorded %s, replayed %s", recordedRaw, replayedRaw)}
+		}
+		return nil
+	}
+	var diffs []string
+	if recorded.StatusCode != replayed.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("statusCode: recorded %d, replayed %d", recorded.StatusCode, replayed.StatusCode))
+	}
+	recordedHeaders, replayedHeaders := recorded.header(), replayed.header()
+	keys := map[string]bool{}
+	for key := range recordedHeaders {
+		keys[key] = true
+	}
+	for key := range replayedHeaders {
+		keys[key] = true
+	}
+	var sortedKeys []string
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+	for _, key := range sortedKeys {
+		ignore := false
+		for _, h := range opts.IgnoreHeaders {
+			ignore = ignore || strings.EqualFold(h, key)
+		}
+		want, got := recordedHeaders[key], replayedHeaders[key]
+		if ignore || (len(want) > 0 && want[0] == redacted) {
+			continue
+		}
+		if !reflect.DeepEqual(want, got) {
+			diffs = append(diffs, fmt.Sprintf("header %s: recorded %q, replayed %q", key, want, got))
+		}
+	}
+	if recorded.Body != redacted {
+		recordedBody, _ := decodeBody(recorded.Body, recorded.IsBase64Encoded)
+		replayedBody, _ := decodeBody(replayed.Body, replayed.IsBase64Encoded)
+		if !jsonEqual(recordedBody, replayedBody) {
+			diffs = append(diffs, fmt.Sprintf("body: recorded %q, replayed %q", recordedBody, replayedBody))
+		}
+	}
+	return diffs
+}
+
+//header merges the headers, multi value headers and cookies of a response
+func (lr localResponse) header() map[string][]string {
+	header := map[string][]string{}
+	for key, value := range lr.Headers {
+		header[strings.ToLower(key)] = []string{value}
+	}
+	for key, values := range lr.MultiValueHeaders {
+		header[strings.ToLower(key)] = values
+	}
+	if len(lr.Cookies) > 0 {
+		header["set-cookie"] = lr.Cookies
+	}
+	return header
+}
+
+//jsonEqual compares two bodies, ignoring formatting and key order when both are json
+func jsonEqual(a, b []byte) bool {
+	var aValue, bValue interface{}
+	if json.Unmarshal(a, &aValue) != nil || json.Unmarshal(b, &bValue) != nil {
+		return string(a) == string(b)
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
@@ -0,0 +1,139 @@
+package apig_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	greeting := "hello"
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.SetCookie(rw, &http.Cookie{Name: "session", Value: "secret"})
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(map[string]string{"greeting": greeting, "path": r.URL.Path})
+	})
+	var sink bytes.Buffer
+	record := apig.Record(apig.LambdaHandlerWithContext(handler, nil), &sink, apig.RecordOptions{
+		RedactHeaders: []string{"authorization", "Set-Cookie"},
+	})
+	authenticated := strings.Replace(testRequest, `"headers": {`, `"headers": {"Authorization": "Bearer token",`, 1)
+	for _, event := range []string{testRequest, testFunctionURLRequest, authenticated} {
+		_, err := record(context.Background(), json.RawMessage(event))
+		require.NoError(t, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(sink.String()), "\n")
+	require.Len(t, lines, 3)
+	for i, line := range lines {
+		require.Contains(t, line, "REDACTED")
+		require.NotContains(t, line, "Bearer token")
+		require.NotContains(t, line, "secret")
+		var recording apig.Recording
+		require.NoError(t, json.Unmarshal([]byte(line), &recording))
+		require.NotEmpty(t, recording.Event)
+		require.NotEmpty(t, recording.Response)
+		require.Equal(t, i == 2, recording.EventRedacted)
+	}
+
+	//the event with a redacted Authorization header is replayed unless it is skipped
+	results, err := apig.Replay(context.Background(), bytes.NewReader(sink.Bytes()), handler, apig.ReplayOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for _, result := range results {
+		require.False(t, result.Skipped)
+		require.Empty(t, result.Diffs)
+	}
+	results, err = apig.Replay(context.Background(), bytes.NewReader(sink.Bytes()), handler, apig.ReplayOptions{SkipRedacted: true})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for i, result := range results {
+		require.Equal(t, i == 2, result.Skipped)
+	}
+
+	greeting = "goodbye"
+	results, err = apig.Replay(context.Background(), bytes.NewReader(sink.Bytes()), handler, apig.ReplayOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for i, result := range results {
+		require.Equal(t, i+1, result.Line)
+		require.Len(t, result.Diffs, 1)
+		require.Contains(t, result.Diffs[0], "body:")
+		require.Contains(t, result.Diffs[0], "goodbye")
+	}
+}
+
+func TestReplayRestoresRedactedHeaders(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		session, err := r.Cookie("session")
+		if r.Header.Get("Authorization") != "Bearer token" || err != nil || session.Value != "abc" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write([]byte("welcome"))
+	})
+	var sink bytes.Buffer
+	record := apig.Record(apig.LambdaHandlerWithContext(handler, nil), &sink, apig.RecordOptions{
+		RedactHeaders: []string{"Authorization", "Cookie"},
+	})
+	v1 := strings.Replace(testRequest, `"headers": {`, `"headers": {"Authorization": "Bearer token", "Cookie": "session=abc",`, 1)
+	v2 := apigtest.NewV2Request("GET", "/").WithHeader("Authorization", "Bearer token").WithCookie("session", "abc").JSON()
+	for _, event := range []json.RawMessage{json.RawMessage(v1), v2} {
+		resp, err := record(context.Background(), event)
+		require.NoError(t, err)
+		require.Contains(t, fmt.Sprint(resp), "welcome")
+	}
+	require.NotContains(t, sink.String(), "Bearer token")
+	require.NotContains(t, sink.String(), "session=abc")
+
+	//the handler sees the redacted values unless they are restored
+	results, err := apig.Replay(context.Background(), bytes.NewReader(sink.Bytes()), handler, apig.ReplayOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		require.Contains(t, result.Diffs, "statusCode: recorded 200, replayed 401")
+	}
+
+	results, err = apig.Replay(context.Background(), bytes.NewReader(sink.Bytes()), handler, apig.ReplayOptions{
+		Restore: apig.RestoreHeaders(map[string]string{"authorization": "Bearer token", "Cookie": "session=abc"}),
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		require.False(t, result.Skipped)
+		require.Empty(t, result.Diffs)
+	}
+}
+
+func TestRecordRedactsBodies(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("card 4111111111111111"))
+	})
+	var sink bytes.Buffer
+	record := apig.Record(apig.LambdaHandlerWithContext(handler, nil), &sink, apig.RecordOptions{
+		RedactBody: func(body string) string {
+			if strings.Contains(body, "4111") {
+				return "REDACTED"
+			}
+			return body
+		},
+	})
+	_, err := record(context.Background(), json.RawMessage(testRequest))
+	require.NoError(t, err)
+	require.NotContains(t, sink.String(), "4111111111111111")
+
+	//redacted response bodies are not compared
+	results, err := apig.Replay(context.Background(), &sink, handler, apig.ReplayOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.False(t, results[0].Skipped)
+	require.Empty(t, results[0].Diffs)
+}
@@ -0,0 +1,125 @@
+package apig
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+//PanicInfo describes a panic recovered while serving a request
+type PanicInfo struct {
+	//Value is the value passed to panic
+	Value interface{}
+	//Stack is the stack trace of the goroutine that panicked
+	Stack []byte
+	//Method, Path and RequestID identify the request that was being served
+	Method    string
+	Path      string
+	RequestID string
+}
+
+//PanicHandler writes the response for a request whose handler panicked
+//Anything the handler wrote before panicking has been discarded, apart from the CORS headers
+type PanicHandler func(rw http.ResponseWriter, r *http.Request, info PanicInfo)
+
+var panicHandler PanicHandler = defaultPanicHandler
+
+//SetPanicHandler sets the handler that writes the response when an http handler panics, nil restores the default 500 problem+json response
+//The panic is logged and admins are notified before it is called
+func SetPanicHandler(handler PanicHandler) {
+	if handler == nil {
+		handler = defaultPanicHandler
+	}
+	panicHandler = handler
+}
+
+func defaultPanicHandler(rw http.ResponseWriter, r *http.Request, info PanicInfo) {
+	writeProblem(rw, Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusInternalServerError),
+		Status: http.StatusInternalServerError,
+	})
+}
+
+//resetter is implemented by the response writers that buffer the response, so it can be replaced after a panic
+type resetter interface {
+	reset()
+}
+
+//committer is implemented by response writers that send the status and headers as soon as the handler writes, such as StreamResponseWriter
+type committer interface {
+	committed() bool
+}
+
+//withRecovery converts panics in handler into the panic handler's response
+//The response writer must implement resetter, as the serve functions' writers do
+func withRecovery(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		//headers set before the handler, such as by CORS, still apply to the error response
+		header := rw.Header().Clone()
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			info := PanicInfo{
+				Value:     recovered,
+				Stack:     debug.Stack(),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				RequestID: requestID(r),
+			}
+			logger.Printf("Recovered panic serving %s %s (request %s): %v\n%s", info.Method, info.Path, info.RequestID, info.Value, info.Stack)
+			logger.NotifyAdmin(fmt.Sprintf("Panic serving %s %s: %v", info.Method, info.Path, info.Value), map[string]interface{}{
+				"method":    info.Method,
+				"path":      info.Path,
+				"requestId": info.RequestID,
+				"panic":     fmt.Sprint(info.Value),
+				"stack":     string(info.Stack),
+			})
+			//a streamed response can only be cut short once it has started
+			if c, ok := rw.(committer); ok && c.committed() {
+				return
+			}
+			if resettable, ok := rw.(resetter); ok {
+				resettable.reset()
+			}
+			for key, values := range header {
+				rw.Header()[key] = values
+			}
+			writePanicResponse(rw, r, info)
+		}()
+		handler.ServeHTTP(rw, r)
+	})
+}
+
+//writePanicResponse calls the panic handler, falling back to a plain 500 if it panics as well
+func writePanicResponse(rw http.ResponseWriter, r *http.Request, info PanicInfo) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.Printf("Panic handler panicked: %v", recovered)
+			if resettable, ok := rw.(resetter); ok {
+				resettable.reset()
+			}
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}()
+	panicHandler(rw, r, info)
+}
+
+//requestID returns the id of the lambda invocation or apigateway request being served
+func requestID(r *http.Request) string {
+	if lc, ok := lambdacontext.FromContext(r.Context()); ok && lc.AwsRequestID != "" {
+		return lc.AwsRequestID
+	}
+	switch event := EventFrom(r).(type) {
+	case events.APIGatewayProxyRequest:
+		return event.RequestContext.RequestID
+	case events.APIGatewayV2HTTPRequest:
+		return event.RequestContext.RequestID
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package apig_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/require"
+)
+
+var panickingHandler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("X-Partial", "true")
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write([]byte("half a response"))
+	panic("nil map")
+})
+
+func TestServeRecoversPanics(t *testing.T) {
+	tNot := testNotifierLogger()
+	problem := `{"type":"about:blank","title":"Internal Server Error","status":500}`
+	apig.SetCORSConfig(apig.DefaultCORSConfig())
+	defer apig.ResetCORSConfig()
+
+	apigtest.Serve(t, panickingHandler, apigtest.NewV1Request("POST", "/users")).
+		AssertStatus(http.StatusInternalServerError).
+		AssertHeader("Content-Type", "application/problem+json").
+		AssertHeader("Access-Control-Allow-Origin", "*").
+		AssertNoHeader("X-Partial").
+		AssertJSON(problem)
+	require.True(t, tNot.GotNotification("Panic serving POST /users: nil map"))
+
+	apigtest.ServeV2(t, panickingHandler, apigtest.NewV2Request("GET", "/v2")).
+		AssertStatus(http.StatusInternalServerError).
+		AssertNoHeader("X-Partial").
+		AssertJSON(problem)
+	require.True(t, tNot.GotNotification("Panic serving GET /v2"))
+
+	apigtest.ServeALB(t, panickingHandler, apigtest.NewALBRequest("GET", "/alb")).
+		AssertStatus(http.StatusInternalServerError).
+		AssertJSON(problem)
+}
+
+func TestSetPanicHandler(t *testing.T) {
+	testNotifierLogger()
+	defer apig.SetPanicHandler(nil)
+	var info apig.PanicInfo
+	apig.SetPanicHandler(func(rw http.ResponseWriter, r *http.Request, i apig.PanicInfo) {
+		info = i
+		apig.RespondHTTP(rw, map[string]string{"error": "sorry"}, http.StatusServiceUnavailable)
+	})
+
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "aws-request-id"})
+	resp, err := apig.ServeWithContext(ctx, apigtest.NewV1Request("DELETE", "/users/1").Build(), panickingHandler)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.JSONEq(t, `{"error":"sorry"}`, resp.Body)
+	require.Equal(t, "nil map", info.Value)
+	require.Equal(t, "DELETE", info.Method)
+	require.Equal(t, "/users/1", info.Path)
+	require.Equal(t, "aws-request-id", info.RequestID)
+	require.Contains(t, string(info.Stack), "panic")
+
+	//a panicking panic handler still produces a response
+	apig.SetPanicHandler(func(rw http.ResponseWriter, r *http.Request, i apig.PanicInfo) {
+		panic("again")
+	})
+	apigtest.ServeV2(t, panickingHandler, apigtest.NewV2Request("GET", "/")).
+		AssertStatus(http.StatusInternalServerError)
+}
+
+func TestServeStreamRecoversPanics(t *testing.T) {
+	tNot := testNotifierLogger()
+	ctx := context.Background()
+
+	//before anything is sent the panic is answered with a 500
+	panicsEarly := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-Partial", "true")
+		panic("nil map")
+	})
+	var out bytes.Buffer
+	require.NoError(t, apig.ServeStream(ctx, apigtest.NewV2Request("GET", "/stream").Build(), panicsEarly, &out))
+	body := bufio.NewReader(&out)
+	prelude := readPrelude(t, body)
+	require.Equal(t, http.StatusInternalServerError, prelude.StatusCode)
+	require.Equal(t, "application/problem+json", prelude.Headers["Content-Type"])
+	require.Empty(t, prelude.Headers["X-Partial"])
+	rest, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type":"about:blank","title":"Internal Server Error","status":500}`, string(rest))
+	require.True(t, tNot.GotNotification("Panic serving GET /stream: nil map"))
+
+	//once the response has started the stream ends where the handler panicked
+	out.Reset()
+	require.NoError(t, apig.ServeStream(ctx, apigtest.NewV2Request("GET", "/stream").Build(), panickingHandler, &out))
+	body = bufio.NewReader(&out)
+	prelude = readPrelude(t, body)
+	require.Equal(t, http.StatusCreated, prelude.StatusCode)
+	rest, err = ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, "half a response", string(rest))
+}
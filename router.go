@@ -0,0 +1,272 @@
+package apig
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+//anyMethod matches every method, as apigateway's ANY method does
+const anyMethod = "ANY"
+
+//Router is an http handler that routes requests with apigateway's path templates, such as /users/{id} and /files/{proxy+}
+//Requests served from a rest or http api event use the event's resource or route key when the router has it and it isn't greedy, otherwise the path is matched locally
+//The most specific template with a handler for the method wins: literal segments before {param} segments before a greedy {param+}
+type Router struct {
+	routes   map[string]*route
+	NotFound http.Handler
+}
+
+//route is a path template and its handlers by method
+type route struct {
+	template string
+	segments []routeSegment
+	handlers map[string]http.Handler
+}
+
+type segmentKind int
+
+const (
+	literalSegment segmentKind = iota
+	paramSegment
+	greedySegment
+)
+
+type routeSegment struct {
+	kind  segmentKind
+	value string
+}
+
+//NewRouter creates a router with no routes
+func NewRouter() *Router {
+	return &Router{routes: make(map[string]*route)}
+}
+
+//Handle registers handler for method, or ANY for every method without its own handler, and template
+//Templates are matched the way apigateway does, a greedy {param+} must be the last segment and matches one or more segments
+//It panics if the template is invalid or already has a handler for method
+func (rt *Router) Handle(method, template string, handler http.Handler) {
+	if rt.routes == nil {
+		rt.routes = make(map[string]*route)
+	}
+	method = strings.ToUpper(method)
+	rte, ok := rt.routes[template]
+	if !ok {
+		rte = &route{template: template, segments: parseTemplate(template), handlers: make(map[string]http.Handler)}
+		rt.routes[template] = rte
+	}
+	if _, ok := rte.handlers[method]; ok {
+		panic("apig: multiple handlers for " + method + " " + template)
+	}
+	rte.handlers[method] = handler
+}
+
+//HandleFunc registers a handler function for method and template, see Handle
+func (rt *Router) HandleFunc(method, template string, handler func(http.ResponseWriter, *http.Request)) {
+	rt.Handle(method, template, http.HandlerFunc(handler))
+}
+
+//ServeHTTP calls the handler for the request's route, responding with a 404 if no template matches or a 405 if the template has no handler for the method
+func (rt *Router) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rte, params := rt.routeFromEvent(r)
+	if rte == nil {
+		var pathMatch *route
+		rte, params, pathMatch = rt.match(r.Method, r.URL.EscapedPath())
+		if rte == nil && pathMatch != nil {
+			rte = pathMatch
+		}
+	}
+	if rte == nil {
+		if rt.NotFound != nil {
+			rt.NotFound.ServeHTTP(rw, r)
+			return
+		}
+		writeProblem(rw, Problem{Type: "about:blank", Title: http.StatusText(http.StatusNotFound), Status: http.StatusNotFound})
+		return
+	}
+	handler, ok := rte.handlers[r.Method]
+	if !ok {
+		handler, ok = rte.handlers[anyMethod]
+	}
+	if !ok {
+		rw.Header().Set("Allow", strings.Join(rte.methods(), ", "))
+		writeProblem(rw, Problem{Type: "about:blank", Title: http.StatusText(http.StatusMethodNotAllowed), Status: http.StatusMethodNotAllowed})
+		return
+	}
+	ctx := context.WithValue(r.Context(), routeContextKey, matchedRoute{template: rte.template, params: params})
+	handler.ServeHTTP(rw, r.WithContext(ctx))
+}
+
+//routeFromEvent returns the route apigateway matched for the request, if the router has one with the same template
+func (rt *Router) routeFromEvent(r *http.Request) (*route, map[string]string) {
+	var template string
+	var params map[string]string
+	switch event := EventFrom(r).(type) {
+	case events.APIGatewayProxyRequest:
+		template, params = event.Resource, event.PathParameters
+	case events.APIGatewayV2HTTPRequest:
+		if parts := strings.SplitN(event.RouteKey, " ", 2); len(parts) == 2 {
+			template = parts[1]
+		}
+		params = event.PathParameters
+	}
+	rte, ok := rt.routes[template]
+	if !ok || template == "" {
+		return nil, nil
+	}
+	//a greedy template such as /{proxy+} is usually a catch all in front of the router, so the path is matched locally to reach more specific routes
+	if n := len(rte.segments); n > 0 && rte.segments[n-1].kind == greedySegment {
+		return nil, nil
+	}
+	return rte, params
+}
+
+//match finds the most specific route for method and an escaped path
+//If the path matches but no route has a handler for the method, the most specific route for the path is returned as pathMatch
+func (rt *Router) match(method, path string) (best *route, bestParams map[string]string, pathMatch *route) {
+	segments := splitPath(path)
+	for _, rte := range rt.routes {
+		params, ok := rte.match(segments)
+		if !ok {
+			continue
+		}
+		if pathMatch == nil || rte.moreSpecific(pathMatch) {
+			pathMatch = rte
+		}
+		if !rte.handles(method) {
+			continue
+		}
+		if best == nil || rte.moreSpecific(best) {
+			best, bestParams = rte, params
+		}
+	}
+	return best, bestParams, pathMatch
+}
+
+//handles reports whether the route has a handler for method
+func (rte *route) handles(method string) bool {
+	_, ok := rte.handlers[method]
+	if !ok {
+		_, ok = rte.handlers[anyMethod]
+	}
+	return ok
+}
+
+//match matches the route against the segments of an escaped path, returning the unescaped path parameters
+func (rte *route) match(segments []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, seg := range rte.segments {
+		if seg.kind == greedySegment {
+			if i >= len(segments) {
+				return nil, false
+			}
+			value, err := url.PathUnescape(strings.Join(segments[i:], "/"))
+			if err != nil {
+				return nil, false
+			}
+			params[seg.value] = value
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		value, err := url.PathUnescape(segments[i])
+		if err != nil {
+			return nil, false
+		}
+		switch seg.kind {
+		case literalSegment:
+			if value != seg.value {
+				return nil, false
+			}
+		case paramSegment:
+			if value == "" {
+				return nil, false
+			}
+			params[seg.value] = value
+		}
+	}
+	if len(segments) != len(rte.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+//moreSpecific reports whether the route should be preferred over other when both match a path
+func (rte *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(rte.segments) && i < len(other.segments); i++ {
+		if rte.segments[i].kind != other.segments[i].kind {
+			return rte.segments[i].kind < other.segments[i].kind
+		}
+	}
+	if len(rte.segments) != len(other.segments) {
+		return len(rte.segments) > len(other.segments)
+	}
+	return rte.template < other.template
+}
+
+//methods returns the methods the route has handlers for, for the Allow header
+func (rte *route) methods() []string {
+	methods := make([]string, 0, len(rte.handlers))
+	for method := range rte.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+//parseTemplate splits a path template into segments, panicking if it is invalid
+func parseTemplate(template string) []routeSegment {
+	if !strings.HasPrefix(template, "/") {
+		panic("apig: path template must start with /: " + template)
+	}
+	parts := splitPath(template)
+	segments := make([]routeSegment, len(parts))
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				panic("apig: invalid path template segment " + part + " in " + template)
+			}
+			segments[i] = routeSegment{kind: literalSegment, value: part}
+			continue
+		}
+		name := part[1 : len(part)-1]
+		kind := paramSegment
+		if strings.HasSuffix(name, "+") {
+			if i != len(parts)-1 {
+				panic("apig: greedy path parameter must be the last segment: " + template)
+			}
+			name, kind = strings.TrimSuffix(name, "+"), greedySegment
+		}
+		if name == "" || strings.ContainsAny(name, "{}+") {
+			panic("apig: invalid path parameter " + part + " in " + template)
+		}
+		segments[i] = routeSegment{kind: kind, value: name}
+	}
+	return segments
+}
+
+//splitPath splits a path into its segments, the root path has none
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+//matchedRoute is the route a Router matched, stored in the request context
+type matchedRoute struct {
+	template string
+	params   map[string]string
+}
+
+//RouteTemplate returns the path template a Router matched for the request, or an empty string if it wasn't routed by one
+func RouteTemplate(r *http.Request) string {
+	matched, _ := r.Context().Value(routeContextKey).(matchedRoute)
+	return matched.template
+}
@@ -0,0 +1,103 @@
+package apig_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+	"github.com/stretchr/testify/require"
+)
+
+func routeEcho(name string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(rw, "%s %s %v", name, apig.RouteTemplate(r), apig.PathParameters(r))
+	}
+}
+
+func testRouter() *apig.Router {
+	router := apig.NewRouter()
+	router.HandleFunc("GET", "/users", routeEcho("list"))
+	router.HandleFunc("POST", "/users", routeEcho("create"))
+	router.HandleFunc("GET", "/users/{id}", routeEcho("get"))
+	router.HandleFunc("ANY", "/users/{id}", routeEcho("any"))
+	router.HandleFunc("GET", "/users/me", routeEcho("me"))
+	router.HandleFunc("GET", "/users/{id}/files/{path+}", routeEcho("file"))
+	router.HandleFunc("ANY", "/{proxy+}", routeEcho("proxy"))
+	return router
+}
+
+func TestRouterMatchesLocally(t *testing.T) {
+	router := testRouter()
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{"GET", "/users", "list /users map[]"},
+		{"POST", "/users", "create /users map[]"},
+		{"GET", "/users/42", "get /users/{id} map[id:42]"},
+		{"DELETE", "/users/42", "any /users/{id} map[id:42]"},
+		{"GET", "/users/me", "me /users/me map[]"},
+		{"GET", "/users/42/files/docs/cv.pdf", "file /users/{id}/files/{path+} map[id:42 path:docs/cv.pdf]"},
+		{"PUT", "/users/42/files/docs/cv.pdf", "proxy /{proxy+} map[proxy:users/42/files/docs/cv.pdf]"},
+		{"GET", "/other", "proxy /{proxy+} map[proxy:other]"},
+	} {
+		apigtest.Serve(t, router, apigtest.NewV1Request(tc.method, tc.path)).
+			AssertStatus(http.StatusOK).
+			AssertBody(tc.body)
+	}
+
+	apigtest.ServeV2(t, router, apigtest.NewV2Request("GET", "/users/a%2Fb")).
+		AssertBody("get /users/{id} map[id:a/b]")
+
+	//a greedy parameter doesn't match the root path
+	apigtest.Serve(t, router, apigtest.NewV1Request("GET", "/")).
+		AssertStatus(http.StatusNotFound).
+		AssertHeader("Content-Type", "application/problem+json")
+}
+
+func TestRouterUsesEventRoute(t *testing.T) {
+	router := testRouter()
+
+	//apigateway's match is used even where the local match would differ
+	apigtest.Serve(t, router, apigtest.NewV1Request("GET", "/users/me").WithResource("/users/{id}", map[string]string{"id": "me"})).
+		AssertBody("get /users/{id} map[id:me]")
+
+	//a greedy resource in front of the router is matched locally, so the specific routes are reached
+	apigtest.Serve(t, router, apigtest.NewV1Request("GET", "/users/42").WithResource("/{proxy+}", map[string]string{"proxy": "users/42"})).
+		AssertBody("get /users/{id} map[id:42]")
+	apigtest.ServeV2(t, router, apigtest.NewV2Request("PATCH", "/users/me").WithRoute("ANY /{proxy+}", map[string]string{"proxy": "users/me"})).
+		AssertBody("any /users/{id} map[id:me]")
+	apigtest.ServeV2(t, router, apigtest.NewV2Request("PATCH", "/other").WithRoute("ANY /{proxy+}", map[string]string{"proxy": "other"})).
+		AssertBody("proxy /{proxy+} map[proxy:other]")
+
+	//resources the router doesn't have, and the $default route, are matched locally
+	apigtest.Serve(t, router, apigtest.NewV1Request("GET", "/users/7").WithResource("/v1/users/{userId}", map[string]string{"userId": "7"})).
+		AssertBody("get /users/{id} map[id:7]")
+	apigtest.ServeV2(t, router, apigtest.NewV2Request("GET", "/users/7").WithRoute("$default", nil)).
+		AssertBody("get /users/{id} map[id:7]")
+
+	root := apig.NewRouter()
+	root.HandleFunc("GET", "/", routeEcho("root"))
+	apigtest.Serve(t, root, apigtest.NewV1Request("GET", "/").WithResource("/", nil)).
+		AssertBody("root / map[]")
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	router := apig.NewRouter()
+	router.HandleFunc("GET", "/users", routeEcho("list"))
+	router.HandleFunc("post", "/users", routeEcho("create"))
+	apigtest.Serve(t, router, apigtest.NewV1Request("DELETE", "/users")).
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertHeader("Allow", "GET, POST")
+
+	router.NotFound = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	apigtest.Serve(t, router, apigtest.NewV1Request("GET", "/missing")).
+		AssertStatus(http.StatusTeapot)
+
+	require.Panics(t, func() { router.HandleFunc("GET", "/users", routeEcho("again")) })
+	require.Panics(t, func() { router.HandleFunc("GET", "/{proxy+}/more", routeEcho("invalid")) })
+	require.Panics(t, func() { router.HandleFunc("GET", "users", routeEcho("invalid")) })
+}
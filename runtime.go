@@ -0,0 +1,318 @@
+package apig
+
+//The lambda runtime api is documented at https://docs.aws.amazon.com/lambda/latest/dg/runtimes-api.html
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+const runtimeAPIVersion = "2018-06-01"
+
+var ErrNoRuntimeAPI = errors.New("AWS_LAMBDA_RUNTIME_API is not set")
+
+//runtimeError is the error format documented for the lambda runtime api
+type runtimeError struct {
+	ErrorMessage string   `json:"errorMessage"`
+	ErrorType    string   `json:"errorType"`
+	StackTrace   []string `json:"stackTrace,omitempty"`
+}
+
+func newRuntimeError(err error) runtimeError {
+	errorType := fmt.Sprintf("%T", err)
+	if i := strings.LastIndex(errorType, "."); i >= 0 {
+		errorType = errorType[i+1:]
+	}
+	return runtimeError{ErrorMessage: err.Error(), ErrorType: strings.TrimPrefix(errorType, "*")}
+}
+
+//invocationPanic is returned when the lambda handler panics
+type invocationPanic struct {
+	value interface{}
+	stack []string
+}
+
+func (p invocationPanic) Error() string {
+	return fmt.Sprintf("%v", p.value)
+}
+
+//Invocation is an event fetched from the lambda runtime api
+type Invocation struct {
+	RequestID          string
+	Deadline           time.Time
+	InvokedFunctionArn string
+	TraceID            string
+	Event              json.RawMessage
+}
+
+//Context returns a context carrying the invocation's deadline and lambda context
+func (inv *Invocation) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := lambdacontext.NewContext(parent, &lambdacontext.LambdaContext{
+		AwsRequestID:       inv.RequestID,
+		InvokedFunctionArn: inv.InvokedFunctionArn,
+	})
+	if inv.Deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, inv.Deadline)
+}
+
+//RuntimeClient talks to the lambda runtime api, it is a replacement for lambda.Start that doesn't need the rpc shim
+type RuntimeClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+//NewRuntimeClient creates a client for the runtime api at api, which is the host:port given in AWS_LAMBDA_RUNTIME_API
+func NewRuntimeClient(api string) *RuntimeClient {
+	return &RuntimeClient{
+		baseURL: "http://" + api + "/" + runtimeAPIVersion,
+		//fetching the next invocation blocks until there is one, so there is no timeout
+		client: &http.Client{},
+	}
+}
+
+//Next blocks until the next invocation is available
+func (c *RuntimeClient) Next(ctx context.Context) (*Invocation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/runtime/invocation/next", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to fetch next invocation: %s %s", resp.Status, body)
+	}
+	inv := &Invocation{
+		RequestID:          resp.Header.Get("Lambda-Runtime-Aws-Request-Id"),
+		InvokedFunctionArn: resp.Header.Get("Lambda-Runtime-Invoked-Function-Arn"),
+		TraceID:            resp.Header.Get("Lambda-Runtime-Trace-Id"),
+		Event:              body,
+	}
+	if deadline, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+		inv.Deadline = time.Unix(0, deadline*int64(time.Millisecond))
+	}
+	return inv, nil
+}
+
+//Respond posts the json encoded response for an invocation
+func (c *RuntimeClient) Respond(ctx context.Context, requestID string, response interface{}) error {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return c.postResponse(ctx, requestID, body)
+}
+
+func (c *RuntimeClient) postResponse(ctx context.Context, requestID string, body []byte) error {
+	return c.post(ctx, "/runtime/invocation/"+requestID+"/response", body, "")
+}
+
+//ReportError reports that an invocation failed
+func (c *RuntimeClient) ReportError(ctx context.Context, requestID string, err error) error {
+	return c.postError(ctx, "/runtime/invocation/"+requestID+"/error", err)
+}
+
+//ReportInitError reports that the function failed to initialise, the runtime should exit after calling this
+func (c *RuntimeClient) ReportInitError(ctx context.Context, err error) error {
+	return c.postError(ctx, "/runtime/init/error", err)
+}
+
+func (c *RuntimeClient) postError(ctx context.Context, path string, err error) error {
+	rErr := newRuntimeError(err)
+	var p invocationPanic
+	if errors.As(err, &p) {
+		rErr.ErrorType = "Runtime.Panic"
+		rErr.StackTrace = p.stack
+	}
+	body, jsonerr := json.Marshal(rErr)
+	if jsonerr != nil {
+		return jsonerr
+	}
+	return c.post(ctx, path, body, rErr.ErrorType)
+}
+
+func (c *RuntimeClient) post(ctx context.Context, path string, body []byte, errorType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if errorType != "" {
+		req.Header.Set("Lambda-Runtime-Function-Error-Type", errorType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Runtime api rejected %s: %s %s", path, resp.Status, respBody)
+	}
+	return nil
+}
+
+//Run fetches and handles invocations until ctx is done or the runtime api can't be reached
+//Handler errors, panics and responses that can't be encoded as json are reported as failed invocations
+func (c *RuntimeClient) Run(ctx context.Context, handler lambdaContextHandlerFunc) error {
+	for {
+		inv, err := c.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		invCtx, cancel := inv.Context(ctx)
+		os.Setenv("_X_AMZN_TRACE_ID", inv.TraceID)
+		resp, err := invoke(invCtx, handler, inv.Event)
+		cancel()
+		var body []byte
+		if err == nil {
+			//a response that can't be encoded fails the invocation rather than stopping the runtime
+			body, err = json.Marshal(resp)
+		}
+		if err != nil {
+			logger.Println(err.Error())
+			err = c.ReportError(ctx, inv.RequestID, err)
+		} else {
+			err = c.postResponse(ctx, inv.RequestID, body)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+}
+
+//RunStream fetches invocations until ctx is done, streaming the http handler's responses with ServeStream
+func (c *RuntimeClient) RunStream(ctx context.Context, handler http.Handler) error {
+	for {
+		inv, err := c.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		var req events.APIGatewayV2HTTPRequest
+		if err = json.Unmarshal(inv.Event, &req); err != nil {
+			err = c.ReportError(ctx, inv.RequestID, err)
+		} else {
+			invCtx, cancel := inv.Context(ctx)
+			os.Setenv("_X_AMZN_TRACE_ID", inv.TraceID)
+			err = c.postStream(invCtx, inv.RequestID, req, handler)
+			cancel()
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+}
+
+//postStream serves the request with ServeStream and posts the response to the runtime api as it is written
+//Errors after the response has started are reported in the trailers, as documented for streamed responses
+func (c *RuntimeClient) postStream(ctx context.Context, requestID string, req events.APIGatewayV2HTTPRequest, handler http.Handler) error {
+	pr, pw := io.Pipe()
+	runtimeReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/runtime/invocation/"+requestID+"/response", pr)
+	if err != nil {
+		return err
+	}
+	runtimeReq.Header.Set("Content-Type", streamingContentType)
+	runtimeReq.Header.Set("Lambda-Runtime-Function-Response-Mode", "streaming")
+	//trailer keys have to be declared before the request is sent
+	runtimeReq.Trailer = http.Header{"Lambda-Runtime-Function-Error-Type": nil, "Lambda-Runtime-Function-Error-Body": nil}
+	go func() {
+		if err := ServeStream(ctx, req, handler, pw); err != nil {
+			rErr := newRuntimeError(err)
+			errBody, _ := json.Marshal(rErr)
+			runtimeReq.Trailer.Set("Lambda-Runtime-Function-Error-Type", rErr.ErrorType)
+			runtimeReq.Trailer.Set("Lambda-Runtime-Function-Error-Body", base64.StdEncoding.EncodeToString(errBody))
+		}
+		pw.Close()
+	}()
+	resp, err := c.client.Do(runtimeReq)
+	//unblocks the handler if the runtime api stopped reading early
+	defer pr.Close()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Runtime api rejected streamed response: %s", resp.Status)
+	}
+	return nil
+}
+
+//invoke calls the handler, turning a panic into an error carrying the stack trace
+func invoke(ctx context.Context, handler lambdaContextHandlerFunc, event json.RawMessage) (resp interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = invocationPanic{value: recovered, stack: strings.Split(string(debug.Stack()), "\n")}
+		}
+	}()
+	return handler(ctx, event)
+}
+
+//StartRuntime serves invocations from the lambda runtime api using LambdaHandlerWithContext, without aws-lambda-go's rpc shim or apex
+//It only returns if the runtime api can't be reached, in which case the process exits
+func StartRuntime(handler http.Handler) {
+	startRuntime(func(ctx context.Context, c *RuntimeClient) error {
+		return c.Run(ctx, LambdaHandlerWithContext(handler, nil))
+	}, handler)
+}
+
+//StartRuntimeStream serves function url invocations from the lambda runtime api, streaming responses with ServeStream
+func StartRuntimeStream(handler http.Handler) {
+	startRuntime(func(ctx context.Context, c *RuntimeClient) error {
+		return c.RunStream(ctx, handler)
+	}, handler)
+}
+
+func startRuntime(run func(ctx context.Context, c *RuntimeClient) error, handler http.Handler) {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		logger.Println(ErrNoRuntimeAPI.Error())
+		os.Exit(1)
+	}
+	ctx := context.Background()
+	c := NewRuntimeClient(api)
+	if handler == nil {
+		if err := c.ReportInitError(ctx, ErrNoHandler); err != nil {
+			logger.Println(err.Error())
+		}
+		os.Exit(1)
+	}
+	if err := run(ctx, c); err != nil {
+		logger.Println(err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,115 @@
+package apig_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeClientRun(t *testing.T) {
+	api := apigtest.NewRuntimeAPI()
+	defer api.Close()
+
+	//the handler runs on Run's goroutine, so it uses assert rather than require
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		lc, ok := lambdacontext.FromContext(r.Context())
+		if !assert.True(t, ok) {
+			return
+		}
+		_, hasDeadline := r.Context().Deadline()
+		assert.True(t, hasDeadline)
+		rw.Write([]byte(lc.AwsRequestID))
+	})
+	fallback := func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		if bytes.Contains(event, []byte("panic")) {
+			panic("fallback panicked")
+		}
+		if bytes.Contains(event, []byte("unencodable")) {
+			return map[string]interface{}{"unencodable": make(chan int)}, nil
+		}
+		return nil, errors.New("unsupported event")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- apig.NewRuntimeClient(api.Address()).Run(ctx, apig.LambdaHandlerWithContext(handler, fallback))
+	}()
+
+	result, err := api.Invoke(ctx, []byte(testRequest))
+	require.NoError(t, err)
+	require.Nil(t, result.Error)
+	var resp events.APIGatewayProxyResponse
+	require.NoError(t, json.Unmarshal(result.Body, &resp))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "apigtest-1", resp.Body)
+
+	result, err = api.Invoke(ctx, []byte(`{"source":"aws.events"}`))
+	require.NoError(t, err)
+	require.Equal(t, &apigtest.RuntimeError{ErrorMessage: "unsupported event", ErrorType: "errorString"}, result.Error)
+
+	result, err = api.Invoke(ctx, []byte(`{"panic":true}`))
+	require.NoError(t, err)
+	require.Equal(t, "Runtime.Panic", result.Error.ErrorType)
+	require.Equal(t, "fallback panicked", result.Error.ErrorMessage)
+	require.NotEmpty(t, result.Error.StackTrace)
+
+	//a response that can't be encoded fails the invocation and the runtime carries on
+	result, err = api.Invoke(ctx, []byte(`{"unencodable":true}`))
+	require.NoError(t, err)
+	require.Equal(t, &apigtest.RuntimeError{ErrorMessage: "json: unsupported type: chan int", ErrorType: "UnsupportedTypeError"}, result.Error)
+	result, err = api.Invoke(ctx, []byte(testRequest))
+	require.NoError(t, err)
+	require.Nil(t, result.Error)
+
+	cancel()
+	require.Equal(t, context.Canceled, <-done)
+}
+
+func TestRuntimeClientRunStream(t *testing.T) {
+	api := apigtest.NewRuntimeAPI()
+	defer api.Close()
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Write([]byte("first "))
+		rw.(http.Flusher).Flush()
+		rw.Write([]byte("second"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go apig.NewRuntimeClient(api.Address()).RunStream(ctx, handler)
+
+	result, err := api.Invoke(ctx, []byte(testFunctionURLRequest))
+	require.NoError(t, err)
+	require.True(t, result.Streamed)
+	require.Nil(t, result.Error)
+	body := bufio.NewReader(bytes.NewReader(result.Body))
+	prelude := readPrelude(t, body)
+	require.Equal(t, "text/plain", prelude.Headers["Content-Type"])
+	rest, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, "first second", string(rest))
+}
+
+func TestRuntimeClientReportInitError(t *testing.T) {
+	api := apigtest.NewRuntimeAPI()
+	defer api.Close()
+
+	err := apig.NewRuntimeClient(api.Address()).ReportInitError(context.Background(), apig.ErrNoHandler)
+	require.NoError(t, err)
+	require.Equal(t, "No handler defined for event of that type", api.InitError().ErrorMessage)
+}
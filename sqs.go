@@ -0,0 +1,104 @@
+package apig
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+//SQSMessageOptions configures how OnSQSMessage processes a batch
+type SQSMessageOptions struct {
+	//Concurrency is the number of messages processed at once, 0 or 1 processes them one at a time
+	//Messages from fifo queues are always processed one at a time, in order
+	Concurrency int
+}
+
+//OnSQSMessage handles sqs events one message at a time, reporting the messages that returned an error or panicked as batch item failures
+//Only the failed messages are retried, the event source mapping needs ReportBatchItemFailures enabled
+//Once a message from a fifo queue fails the rest of the batch is reported as failed without being processed, so messages aren't handled out of order
+//Messages that haven't started by the invocation's deadline are reported as failed, this needs the router's Handle as the fallback of LambdaHandlerWithContext, HandleEvent has no deadline
+func (er *EventRouter) OnSQSMessage(handler func(ctx context.Context, msg events.SQSMessage) error, opts SQSMessageOptions) *EventRouter {
+	return er.OnSQS(func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+		return processSQSMessages(ctx, event, handler, opts), nil
+	})
+}
+
+func processSQSMessages(ctx context.Context, event events.SQSEvent, handler func(context.Context, events.SQSMessage) error, opts SQSMessageOptions) events.SQSEventResponse {
+	failed := make([]bool, len(event.Records))
+	if opts.Concurrency <= 1 || isFIFOBatch(event) {
+		for i, msg := range event.Records {
+			failed[i] = !processSQSMessage(ctx, msg, handler)
+			if failed[i] && isFIFOQueue(msg.EventSourceARN) {
+				for j := i + 1; j < len(failed); j++ {
+					failed[j] = true
+				}
+				break
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, opts.Concurrency)
+		for i, msg := range event.Records {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, msg events.SQSMessage) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				failed[i] = !processSQSMessage(ctx, msg, handler)
+			}(i, msg)
+		}
+		wg.Wait()
+	}
+	resp := events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{}}
+	for i, msg := range event.Records {
+		if failed[i] {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+		}
+	}
+	return resp
+}
+
+//processSQSMessage calls the handler for one message and reports whether it succeeded, logging errors and recovering panics
+//Messages aren't started once the context is done, so they are retried rather than cut off by the lambda timeout
+func processSQSMessage(ctx context.Context, msg events.SQSMessage, handler func(context.Context, events.SQSMessage) error) (ok bool) {
+	if ctx.Err() != nil {
+		return false
+	}
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		stack := debug.Stack()
+		logger.Printf("Recovered panic processing sqs message %s: %v\n%s", msg.MessageId, recovered, stack)
+		logger.NotifyAdmin(fmt.Sprintf("Panic processing sqs message: %v", recovered), map[string]interface{}{
+			"messageId": msg.MessageId,
+			"queue":     msg.EventSourceARN,
+			"panic":     fmt.Sprint(recovered),
+			"stack":     string(stack),
+		})
+		ok = false
+	}()
+	if err := handler(ctx, msg); err != nil {
+		logger.Printf("Failed to process sqs message %s: %s", msg.MessageId, err.Error())
+		return false
+	}
+	return true
+}
+
+func isFIFOBatch(event events.SQSEvent) bool {
+	for _, msg := range event.Records {
+		if isFIFOQueue(msg.EventSourceARN) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFIFOQueue(arn string) bool {
+	return strings.HasSuffix(arn, ".fifo")
+}
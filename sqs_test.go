@@ -0,0 +1,138 @@
+package apig_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func sqsBatch(queueARN string, bodies ...string) json.RawMessage {
+	event := events.SQSEvent{}
+	for i, body := range bodies {
+		event.Records = append(event.Records, events.SQSMessage{
+			MessageId:      fmt.Sprintf("m%d", i+1),
+			Body:           body,
+			EventSource:    "aws:sqs",
+			EventSourceARN: queueARN,
+		})
+	}
+	raw, _ := json.Marshal(event)
+	return raw
+}
+
+func failedIDs(resp interface{}) []string {
+	ids := []string{}
+	for _, failure := range resp.(events.SQSEventResponse).BatchItemFailures {
+		ids = append(ids, failure.ItemIdentifier)
+	}
+	return ids
+}
+
+func TestOnSQSMessage(t *testing.T) {
+	tNot := testNotifierLogger()
+	var mu sync.Mutex
+	var processed []string
+	router := apig.NewEventRouter().OnSQSMessage(func(ctx context.Context, msg events.SQSMessage) error {
+		mu.Lock()
+		processed = append(processed, msg.Body)
+		mu.Unlock()
+		switch msg.Body {
+		case "error":
+			return errors.New("bad message")
+		case "panic":
+			panic("nil map")
+		}
+		return nil
+	}, apig.SQSMessageOptions{})
+	httpHandler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("http"))
+	})
+	lambdaHandler := apig.LambdaHandlerWithContext(httpHandler, router.Handle)
+	handler := func(event json.RawMessage) (interface{}, error) {
+		return lambdaHandler(context.Background(), event)
+	}
+
+	resp, err := handler(sqsBatch("arn:aws:sqs:us-east-1:123456789012:jobs", "ok", "error", "ok", "panic"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"m2", "m4"}, failedIDs(resp))
+	require.Equal(t, []string{"ok", "error", "ok", "panic"}, processed)
+	require.True(t, tNot.GotNotification("Panic processing sqs message: nil map"))
+
+	resp, err = handler(sqsBatch("arn:aws:sqs:us-east-1:123456789012:jobs", "ok"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"batchItemFailures":[]}`, string(mustMarshal(t, resp)))
+
+	//http events are still served by the http handler
+	resp, err = handler(apigtest.NewV1Request("GET", "/").JSON())
+	require.NoError(t, err)
+	require.Equal(t, "http", resp.(events.APIGatewayProxyResponse).Body)
+
+	//the rest of a fifo batch fails after the first failure, so order is kept
+	processed = nil
+	resp, err = handler(sqsBatch("arn:aws:sqs:us-east-1:123456789012:jobs.fifo", "ok", "error", "ok"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"m2", "m3"}, failedIDs(resp))
+	require.Equal(t, []string{"ok", "error"}, processed)
+}
+
+func TestOnSQSMessageConcurrency(t *testing.T) {
+	testNotifierLogger()
+	var running, maxRunning int32
+	router := apig.NewEventRouter().OnSQSMessage(func(ctx context.Context, msg events.SQSMessage) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		if msg.Body == "error" {
+			return errors.New("bad message")
+		}
+		return nil
+	}, apig.SQSMessageOptions{Concurrency: 3})
+
+	resp, err := router.HandleEvent(sqsBatch("arn:aws:sqs:us-east-1:123456789012:jobs", "ok", "ok", "error", "ok", "ok", "ok", "error", "ok"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"m3", "m7"}, failedIDs(resp))
+	require.Equal(t, int32(3), atomic.LoadInt32(&maxRunning))
+
+}
+
+func TestOnSQSMessageDeadline(t *testing.T) {
+	testNotifierLogger()
+	var processed []string
+	router := apig.NewEventRouter().OnSQSMessage(func(ctx context.Context, msg events.SQSMessage) error {
+		processed = append(processed, msg.MessageId)
+		<-ctx.Done()
+		return ctx.Err()
+	}, apig.SQSMessageOptions{})
+	handler := apig.LambdaHandlerWithContext(http.NotFoundHandler(), router.Handle)
+
+	//the invocation's deadline reaches the messages, the second isn't started once it has passed
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	resp, err := handler(ctx, sqsBatch("arn:aws:sqs:us-east-1:123456789012:jobs", "slow", "next"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"m1", "m2"}, failedIDs(resp))
+	require.Equal(t, []string{"m1"}, processed)
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	raw, err := json.Marshal(v)
+	require.NoError(t, err)
+	return raw
+}
@@ -0,0 +1,132 @@
+package apig
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+//StageMode controls how the stage a request was sent to is exposed to the http handler
+type StageMode int
+
+const (
+	//StageInContext gives the handler the path of the route without the stage or base path, read the stage with Stage
+	StageInContext StageMode = iota
+	//StageInPath keeps the stage or base path at the start of the request path, so the request url is the one the client used
+	StageInPath
+)
+
+//BasePathMapping is the mapping of an api stage onto a custom domain
+type BasePathMapping struct {
+	//BasePath is the path the api is mapped to on the domain, empty for the root of the domain
+	BasePath string
+	//Stage is the stage of the api the domain is mapped to
+	Stage string
+}
+
+//StageMapping describes how the urls clients use relate to apigateway stages, it is used when converting requests in both directions
+type StageMapping struct {
+	//Stages are the stage names recognised as the first path segment on hosts that aren't execute-api or mapped domains, such as when running locally
+	//On execute-api hosts the first segment of a rest api path is always the stage, and for http apis it is when it is one of Stages
+	Stages []string
+	//Domains maps custom domain names to their base path mapping
+	Domains map[string]BasePathMapping
+	//Mode controls whether the stage or base path is kept in the path of converted requests
+	Mode StageMode
+}
+
+var stageMapping StageMapping
+
+//SetStageMapping sets the stage and base path mapping used by the request conversions
+func SetStageMapping(mapping StageMapping) {
+	stageMapping = mapping
+}
+
+var executeAPIHostRe = regexp.MustCompile(`^[a-z0-9]+\.execute-api\.[a-z0-9-]+\.amazonaws\.com$`)
+
+//isExecuteAPIHost reports whether host is the default domain of an api, where the stage is part of the path
+func isExecuteAPIHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return executeAPIHostRe.MatchString(strings.ToLower(host))
+}
+
+//prefix returns the path clients put in front of the api's routes when calling stage on host
+func (m StageMapping) prefix(host, stage string) string {
+	if mapping, ok := m.Domains[strings.ToLower(host)]; ok {
+		return "/" + strings.Trim(mapping.BasePath, "/")
+	}
+	if stage == "" || stage == "$default" {
+		return ""
+	}
+	if isExecuteAPIHost(host) {
+		return "/" + stage
+	}
+	for _, s := range m.Stages {
+		if s == stage {
+			return "/" + stage
+		}
+	}
+	return ""
+}
+
+//split separates the stage from a path the way apigateway would route it, returning the stage and the path of the route
+//restAPI is set for payload format 1.0 requests, where execute-api paths always start with the stage
+func (m StageMapping) split(host, path string, restAPI bool) (string, string) {
+	if mapping, ok := m.Domains[strings.ToLower(host)]; ok {
+		return mapping.Stage, trimPathPrefix(path, "/"+strings.Trim(mapping.BasePath, "/"))
+	}
+	segment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	if segment == "" {
+		return "", path
+	}
+	isStage := restAPI && isExecuteAPIHost(host)
+	for _, s := range m.Stages {
+		isStage = isStage || s == segment
+	}
+	if !isStage {
+		return "", path
+	}
+	return segment, trimPathPrefix(path, "/"+segment)
+}
+
+//route returns the request path an http handler should see for an event's path
+//eventHasPrefix is set when apigateway includes the stage in the event path on execute-api hosts, as http apis do
+func (m StageMapping) route(path, host, stage string, eventHasPrefix bool) string {
+	prefix := m.prefix(host, stage)
+	if _, mapped := m.Domains[strings.ToLower(host)]; mapped || eventHasPrefix {
+		path = trimPathPrefix(path, prefix)
+	}
+	if m.Mode == StageInPath && prefix != "" && prefix != "/" {
+		if path == "/" {
+			return prefix
+		}
+		return prefix + path
+	}
+	return path
+}
+
+//trimPathPrefix removes a prefix of whole path segments from path
+func trimPathPrefix(path, prefix string) string {
+	if prefix == "" || prefix == "/" {
+		return path
+	}
+	if path == prefix {
+		return "/"
+	}
+	if strings.HasPrefix(path, prefix+"/") {
+		return strings.TrimPrefix(path, prefix)
+	}
+	return path
+}
+
+//withStage attaches the stage to a request converted outside of Serve and ServeV2
+func withStage(r *http.Request, stage string) *http.Request {
+	if stage == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), stageContextKey, stage))
+}
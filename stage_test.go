@@ -0,0 +1,133 @@
+package apig_test
+
+import (
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+	"github.com/stretchr/testify/require"
+)
+
+const executeAPIHost = "1234567890.execute-api.us-east-1.amazonaws.com"
+
+func TestStageMappingToStdLib(t *testing.T) {
+	defer apig.SetStageMapping(apig.StageMapping{})
+	domains := map[string]apig.BasePathMapping{"api.example.com": {BasePath: "v1", Stage: "prod"}}
+	tests := []struct {
+		name    string
+		v1      *apigtest.V1Request
+		v2      *apigtest.V2Request
+		context string
+		path    string
+	}{
+		{
+			name:    "rest api on execute-api",
+			v1:      apigtest.NewV1Request("GET", "/users").WithHost(executeAPIHost).WithStage("prod"),
+			context: "https://" + executeAPIHost + "/users",
+			path:    "https://" + executeAPIHost + "/prod/users",
+		},
+		{
+			name:    "http api named stage on execute-api",
+			v2:      apigtest.NewV2Request("GET", "/dev/users").WithHost(executeAPIHost).WithStage("dev"),
+			context: "https://" + executeAPIHost + "/users",
+			path:    "https://" + executeAPIHost + "/dev/users",
+		},
+		{
+			name:    "http api default stage",
+			v2:      apigtest.NewV2Request("GET", "/users").WithHost(executeAPIHost),
+			context: "https://" + executeAPIHost + "/users",
+			path:    "https://" + executeAPIHost + "/users",
+		},
+		{
+			name:    "rest api on a host that only looks like execute-api",
+			v1:      apigtest.NewV1Request("GET", "/users").WithHost("x.execute-apiXfooXamazonaws.com.evil.example").WithStage("prod"),
+			context: "https://x.execute-apiXfooXamazonaws.com.evil.example/users",
+			path:    "https://x.execute-apiXfooXamazonaws.com.evil.example/users",
+		},
+		{
+			name:    "rest api on mapped domain",
+			v1:      apigtest.NewV1Request("GET", "/v1/users").WithHost("api.example.com").WithStage("prod"),
+			context: "https://api.example.com/users",
+			path:    "https://api.example.com/v1/users",
+		},
+		{
+			name:    "http api on mapped domain",
+			v2:      apigtest.NewV2Request("GET", "/v1/users").WithHost("api.example.com").WithStage("prod"),
+			context: "https://api.example.com/users",
+			path:    "https://api.example.com/v1/users",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for mode, expected := range map[apig.StageMode]string{apig.StageInContext: tt.context, apig.StageInPath: tt.path} {
+				apig.SetStageMapping(apig.StageMapping{Domains: domains, Mode: mode})
+				var stReq *http.Request
+				var err error
+				stage := "$default"
+				if tt.v1 != nil {
+					stReq, err = apig.ToStdLibRequest(tt.v1.Build())
+					stage = tt.v1.Build().RequestContext.Stage
+				} else {
+					stReq, err = apig.ToStdLibRequestV2(tt.v2.Build())
+					stage = tt.v2.Build().RequestContext.Stage
+				}
+				require.NoError(t, err)
+				require.Equal(t, expected, stReq.URL.String())
+				require.Equal(t, stReq.URL.Host, stReq.Host)
+				require.Equal(t, stage, apig.Stage(stReq))
+			}
+		})
+	}
+}
+
+func TestStageMappingToApig(t *testing.T) {
+	defer apig.SetStageMapping(apig.StageMapping{})
+	apig.SetStageMapping(apig.StageMapping{
+		Stages:  []string{"qa"},
+		Domains: map[string]apig.BasePathMapping{"api.example.com": {BasePath: "/v1/", Stage: "prod"}},
+	})
+	tests := []struct {
+		url       string
+		stage     string
+		path      string
+		stageV2   string
+		routeHost string
+	}{
+		{"https://" + executeAPIHost + "/prod/users", "prod", "/users", "$default", executeAPIHost},
+		{"https://" + executeAPIHost + "/qa/users", "qa", "/users", "qa", executeAPIHost},
+		{"http://localhost:8080/qa/users", "qa", "/users", "qa", "localhost:8080"},
+		//stages are no longer guessed from the path
+		{"http://localhost:8080/dev/users", "", "/dev/users", "$default", "localhost:8080"},
+		{"https://api.example.com/v1/users", "prod", "/users", "prod", "api.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			require.NoError(t, err)
+			event, err := apig.ToApigRequest(*req)
+			require.NoError(t, err)
+			require.Equal(t, tt.stage, event.RequestContext.Stage)
+			require.Equal(t, tt.path, event.Path)
+			require.Equal(t, req.URL.Path, event.RequestContext.Path)
+			require.Equal(t, tt.routeHost, event.Headers["Host"])
+
+			eventV2, err := apig.ToApigRequestV2(*req)
+			require.NoError(t, err)
+			require.Equal(t, tt.stageV2, eventV2.RequestContext.Stage)
+			require.Equal(t, req.URL.Path, eventV2.RawPath)
+		})
+	}
+}
+
+func TestStageMappingRoundTrip(t *testing.T) {
+	defer apig.SetStageMapping(apig.StageMapping{})
+	apig.SetStageMapping(apig.StageMapping{Mode: apig.StageInPath})
+	req, err := http.NewRequest(http.MethodGet, "https://"+executeAPIHost+"/prod/users/1?page=2", nil)
+	require.NoError(t, err)
+	event, err := apig.ToApigRequest(*req)
+	require.NoError(t, err)
+	stReq, err := apig.ToStdLibRequest(event)
+	require.NoError(t, err)
+	require.Equal(t, req.URL.String(), stReq.URL.String())
+}
@@ -0,0 +1,160 @@
+package apig
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+//streamingContentType is the content type lambda expects for a streamed http response
+const streamingContentType = "application/vnd.awslambda.http-integration-response"
+
+//streamingPreludeDelimiter separates the json status and headers from the body of a streamed response
+var streamingPreludeDelimiter = []byte{0, 0, 0, 0, 0, 0, 0, 0}
+
+//StreamResponseWriter implements the net/http ResponseWriter and Flusher interfaces, writing to a lambda response stream as the response is produced
+//The status and headers are written on the first Write or Flush, so they can't be changed after that
+type StreamResponseWriter struct {
+	w           io.Writer
+	header      http.Header
+	status      int
+	wroteHeader bool
+	err         error
+}
+
+//NewStreamResponseWriter creates a StreamResponseWriter that writes the response in lambda's streaming format to w
+func NewStreamResponseWriter(w io.Writer) *StreamResponseWriter {
+	return &StreamResponseWriter{w: w}
+}
+
+//Header returns the map that will be sent with the first Write or Flush
+func (rw *StreamResponseWriter) Header() http.Header {
+	if rw.header == nil {
+		rw.header = make(map[string][]string)
+	}
+	return rw.header
+}
+
+//WriteHeader sets the status code sent with the first Write or Flush
+func (rw *StreamResponseWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.status = status
+}
+
+func (rw *StreamResponseWriter) Write(data []byte) (int, error) {
+	if err := rw.writePrelude(); err != nil {
+		return 0, err
+	}
+	return rw.w.Write(data)
+}
+
+//Flush sends the status, headers and anything written so far to the client
+func (rw *StreamResponseWriter) Flush() {
+	if err := rw.writePrelude(); err != nil {
+		return
+	}
+	switch f := rw.w.(type) {
+	case http.Flusher:
+		f.Flush()
+	case interface{ Flush() error }:
+		if err := f.Flush(); err != nil {
+			logger.Println(err.Error())
+		}
+	}
+}
+
+//reset discards the status and headers if they haven't been sent yet
+func (rw *StreamResponseWriter) reset() {
+	if rw.wroteHeader {
+		return
+	}
+	rw.header = nil
+	rw.status = 0
+}
+
+//committed reports whether the status and headers have been sent, after which the response can't be replaced
+func (rw *StreamResponseWriter) committed() bool {
+	return rw.wroteHeader
+}
+
+//Close writes the status and headers if nothing else has, it should be called once the handler has returned
+func (rw *StreamResponseWriter) Close() error {
+	return rw.writePrelude()
+}
+
+//writePrelude writes the json encoded status and headers followed by the delimiter, once
+func (rw *StreamResponseWriter) writePrelude() error {
+	if rw.wroteHeader {
+		return rw.err
+	}
+	rw.wroteHeader = true
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	headers, cookies := v2Headers(rw.header)
+	prelude, err := json.Marshal(struct {
+		StatusCode int               `json:"statusCode"`
+		Headers    map[string]string `json:"headers,omitempty"`
+		Cookies    []string          `json:"cookies,omitempty"`
+	}{
+		StatusCode: rw.status,
+		Headers:    headers,
+		Cookies:    cookies,
+	})
+	if err != nil {
+		rw.err = err
+		return err
+	}
+	if _, err = rw.w.Write(append(prelude, streamingPreludeDelimiter...)); err != nil {
+		rw.err = err
+	}
+	return rw.err
+}
+
+//ServeStream handles a lambda function url request using a net/http handler, streaming the response to w as it is written
+//The function url must be configured with the RESPONSE_STREAM invoke mode, which lifts the 6 MB limit on buffered responses
+//A panic before the response has started is answered with a 500, see SetPanicHandler, after that the stream is ended early
+func ServeStream(ctx context.Context, req events.APIGatewayV2HTTPRequest, handler http.Handler, w io.Writer) error {
+	rw := NewStreamResponseWriter(w)
+	shr, err := ToStdLibRequestV2(req)
+	if err != nil {
+		writeProblem(rw, problemFor(err, http.StatusInternalServerError, req.RawPath))
+		return rw.Close()
+	}
+	shr = shr.WithContext(withEvent(ctx, req))
+	withCORS(withRecovery(handler)).ServeHTTP(rw, shr)
+	return rw.Close()
+}
+
+//streamingBody is the response stream returned to aws-lambda-go
+type streamingBody struct {
+	*io.PipeReader
+}
+
+//ContentType tells the lambda runtime the response is a streamed http response
+func (streamingBody) ContentType() string {
+	return streamingContentType
+}
+
+//StreamHandler returns a lambda handler that serves function url requests using ServeStream
+//aws-lambda-go only streams responses when built with the lambda.norpc tag or run on the provided runtimes
+func StreamHandler(handler http.Handler) func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (io.Reader, error) {
+	return func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (io.Reader, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(ServeStream(ctx, req, handler, pw))
+		}()
+		return streamingBody{pr}, nil
+	}
+}
+
+//StartLambdaStream starts the aws lambda runtime, streaming responses with StreamHandler
+func StartLambdaStream(handler http.Handler) {
+	lambda.Start(StreamHandler(handler))
+}
@@ -0,0 +1,178 @@
+package apig_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type streamPrelude struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+	Cookies    []string          `json:"cookies"`
+}
+
+//readPrelude reads the json status and headers from the start of a streamed response
+func readPrelude(t *testing.T, r *bufio.Reader) streamPrelude {
+	prelude, err := parsePrelude(r)
+	require.NoError(t, err)
+	return prelude
+}
+
+func parsePrelude(r *bufio.Reader) (streamPrelude, error) {
+	var prelude streamPrelude
+	var raw []byte
+	for !bytes.HasSuffix(raw, make([]byte, 8)) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return prelude, err
+		}
+		raw = append(raw, b)
+	}
+	err := json.Unmarshal(raw[:len(raw)-8], &prelude)
+	return prelude, err
+}
+
+//runtimeStub is a runtime api that hands out one function url event and checks the streamed response as it arrives
+//Assertions run on the server's goroutines, so they use assert rather than require
+func runtimeStub(t *testing.T, event json.RawMessage, firstEvent chan<- string, rest chan<- string) *httptest.Server {
+	var served int32
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2018-06-01/runtime/invocation/next":
+			if atomic.AddInt32(&served, 1) > 1 {
+				<-r.Context().Done()
+				return
+			}
+			rw.Header().Set("Lambda-Runtime-Aws-Request-Id", "request-id")
+			rw.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(time.Now().Add(time.Minute).UnixNano()/int64(time.Millisecond), 10))
+			rw.Write(event)
+		case "/2018-06-01/runtime/invocation/request-id/response":
+			defer close(rest)
+			assert.Equal(t, "streaming", r.Header.Get("Lambda-Runtime-Function-Response-Mode"))
+			assert.Equal(t, "application/vnd.awslambda.http-integration-response", r.Header.Get("Content-Type"))
+			body := bufio.NewReader(r.Body)
+			prelude, err := parsePrelude(body)
+			if !assert.NoError(t, err) {
+				close(firstEvent)
+				return
+			}
+			assert.Equal(t, http.StatusOK, prelude.StatusCode)
+			assert.Equal(t, "text/event-stream", prelude.Headers["Content-Type"])
+			assert.Equal(t, []string{"session=abc"}, prelude.Cookies)
+			line, err := body.ReadString('\n')
+			assert.NoError(t, err)
+			firstEvent <- line
+			remaining, err := ioutil.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Empty(t, r.Trailer.Get("Lambda-Runtime-Function-Error-Type"))
+			rest <- string(remaining)
+			rw.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("Unexpected runtime api request %s %s", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestServeStreamToRuntimeStub(t *testing.T) {
+	firstEvent := make(chan string, 1)
+	rest := make(chan string, 1)
+	stub := runtimeStub(t, json.RawMessage(testFunctionURLRequest), firstEvent, rest)
+	defer stub.Close()
+
+	received := make(chan struct{})
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		http.SetCookie(rw, &http.Cookie{Name: "session", Value: "abc"})
+		rw.Write([]byte("data: one\n\n"))
+		rw.(http.Flusher).Flush()
+		//the second event is only written once the runtime has received the first
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Error("first event was not streamed before the handler returned")
+		}
+		rw.Write([]byte("data: two\n\n"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- apig.NewRuntimeClient(strings.TrimPrefix(stub.URL, "http://")).RunStream(ctx, handler)
+	}()
+
+	require.Equal(t, "data: one\n", <-firstEvent)
+	close(received)
+	require.Equal(t, "\ndata: two\n\n", <-rest)
+	cancel()
+	require.Equal(t, context.Canceled, <-done)
+}
+
+func TestStreamHandler(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("streamed"))
+	})
+
+	req := events.APIGatewayV2HTTPRequest{RawPath: "/"}
+	req.RequestContext.HTTP.Method = http.MethodPost
+	reader, err := apig.StreamHandler(handler)(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, "application/vnd.awslambda.http-integration-response", reader.(interface{ ContentType() string }).ContentType())
+
+	body := bufio.NewReader(reader)
+	prelude := readPrelude(t, body)
+	require.Equal(t, http.StatusCreated, prelude.StatusCode)
+	rest, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, "streamed", string(rest))
+}
+
+//TestServeDefaultsToOK checks the buffered writers default to 200 without WriteHeader, as the streaming writer does
+func TestServeDefaultsToOK(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("no WriteHeader"))
+	})
+
+	resp, err := apig.Serve(events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/"}, handler)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "no WriteHeader", resp.Body)
+
+	v2req := events.APIGatewayV2HTTPRequest{RawPath: "/"}
+	v2req.RequestContext.HTTP.Method = http.MethodGet
+	v2resp, err := apig.ServeV2(v2req, handler)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, v2resp.StatusCode)
+	require.Equal(t, "no WriteHeader", v2resp.Body)
+
+	albresp, err := apig.ServeALB(events.ALBTargetGroupRequest{HTTPMethod: http.MethodGet, Path: "/"}, handler)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, albresp.StatusCode)
+	require.Equal(t, "200 OK", albresp.StatusDescription)
+
+	var streamed bytes.Buffer
+	require.NoError(t, apig.ServeStream(context.Background(), v2req, handler, &streamed))
+	require.Equal(t, http.StatusOK, readPrelude(t, bufio.NewReader(&streamed)).StatusCode)
+
+	//a handler that writes nothing still responds 200, as net/http does
+	empty := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+	resp, err = apig.Serve(events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/"}, empty)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,64 @@
+package apig
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+)
+
+//LambdaTransport is an http.RoundTripper that sends requests to a lambda handler in process
+//Each request is converted to the event apigateway would send and the handler's response converted back,
+//so http clients and sdks can be tested against a handler without a network or a deployment
+type LambdaTransport struct {
+	invoke lambdaContextHandlerFunc
+	opts   LocalOptions
+}
+
+//NewLambdaTransport returns a transport that serves requests with handler through Serve or ServeV2
+//version is the payload format version of the events, "1.0" or "2.0"
+func NewLambdaTransport(handler http.Handler, version string) *LambdaTransport {
+	return NewLambdaFuncTransport(LambdaHandlerWithContext(handler, nil), LocalOptions{PayloadVersion: version})
+}
+
+//NewLambdaFuncTransport returns a transport that sends requests to any lambda handler func, such as one built with LambdaHandlerWithContext or apigtest.RuntimeAPI.Handler
+//opts controls the events the same way it does for LocalHandler
+func NewLambdaFuncTransport(invoke lambdaContextHandlerFunc, opts LocalOptions) *LambdaTransport {
+	return &LambdaTransport{invoke: invoke, opts: opts}
+}
+
+//RoundTrip implements http.RoundTripper
+//If the handler returns an error the response is the 502 apigateway would return
+func (t *LambdaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	r := req
+	if r.RemoteAddr == "" {
+		r = r.Clone(r.Context())
+		r.RemoteAddr = "127.0.0.1"
+	}
+	event, err := localEvent(r, t.opts)
+	if err != nil {
+		return nil, err
+	}
+	var shr *http.Response
+	resp, err := t.invoke(r.Context(), event)
+	if err != nil {
+		logger.Println(err.Error())
+		body := []byte(`{"message":"Internal server error"}`)
+		shr = &http.Response{
+			Status:        "502 Bad Gateway",
+			StatusCode:    http.StatusBadGateway,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}
+	} else if shr, err = toLocalResponse(resp); err != nil {
+		return nil, err
+	}
+	shr.Request = req
+	return shr, nil
+}
@@ -0,0 +1,49 @@
+package apig_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLambdaTransport(t *testing.T) {
+	for _, version := range []string{"1.0", "2.0"} {
+		t.Run(version, func(t *testing.T) {
+			client := &http.Client{Transport: apig.NewLambdaTransport(localEchoHandler(t), version)}
+			req, err := http.NewRequest(http.MethodPost, "https://api.example.com/users/a%20b?tag=x&tag=y%26z", strings.NewReader(`{"name":"zoë"}`))
+			require.NoError(t, err)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			require.Len(t, resp.Cookies(), 2)
+			require.Equal(t, req, resp.Request)
+			var echo map[string]interface{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&echo))
+			require.Equal(t, "/users/a b", echo["path"])
+			require.Equal(t, map[string]interface{}{"tag": []interface{}{"x", "y&z"}}, echo["query"])
+			require.Equal(t, "abc", echo["session"])
+			require.Equal(t, `{"name":"zoë"}`, echo["body"])
+		})
+	}
+}
+
+func TestLambdaFuncTransportError(t *testing.T) {
+	failing := func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		return nil, errors.New("handler failed")
+	}
+	client := &http.Client{Transport: apig.NewLambdaFuncTransport(failing, apig.LocalOptions{})}
+	resp, err := client.Get("https://api.example.com/users")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
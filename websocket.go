@@ -0,0 +1,312 @@
+package apig
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+//WebSocketMessage is a websocket api event with its body decoded
+type WebSocketMessage struct {
+	ConnectionID string
+	RouteKey     string
+	Body         []byte
+	Event        events.APIGatewayWebsocketProxyRequest
+}
+
+//Reply sends data to the connection the message came from, using the connection manager set with SetConnectionManager
+func (m *WebSocketMessage) Reply(ctx context.Context, data []byte) error {
+	return PostToConnection(ctx, m.ConnectionID, data)
+}
+
+//WebSocketHandler handles the events of a websocket api
+//Returning an error from OnConnect rejects the connection, an HTTPError chooses the status, otherwise it is a 500
+type WebSocketHandler interface {
+	OnConnect(ctx context.Context, msg *WebSocketMessage) error
+	OnDisconnect(ctx context.Context, msg *WebSocketMessage) error
+	//OnMessage returns the body of the route response, which is sent to the client if the route has a route response
+	//A string or []byte is sent as is, anything else is encoded as json and nil sends no body
+	OnMessage(ctx context.Context, msg *WebSocketMessage) (interface{}, error)
+}
+
+//WebSocketMessageFunc handles messages for a route
+type WebSocketMessageFunc func(ctx context.Context, msg *WebSocketMessage) (interface{}, error)
+
+//WebSocketRouter is a WebSocketHandler that dispatches messages on their route key
+//Messages for routes without a handler go to the $default route, and are answered with a 404 if there isn't one
+type WebSocketRouter struct {
+	Connect    func(ctx context.Context, msg *WebSocketMessage) error
+	Disconnect func(ctx context.Context, msg *WebSocketMessage) error
+	routes     map[string]WebSocketMessageFunc
+}
+
+//NewWebSocketRouter creates a router with no routes, connections are accepted unless Connect is set
+func NewWebSocketRouter() *WebSocketRouter {
+	return &WebSocketRouter{routes: make(map[string]WebSocketMessageFunc)}
+}
+
+//Route handles messages with routeKey, such as sendmessage or $default
+func (wr *WebSocketRouter) Route(routeKey string, handler WebSocketMessageFunc) *WebSocketRouter {
+	if wr.routes == nil {
+		wr.routes = make(map[string]WebSocketMessageFunc)
+	}
+	wr.routes[routeKey] = handler
+	return wr
+}
+
+//OnConnect calls Connect if it is set
+func (wr *WebSocketRouter) OnConnect(ctx context.Context, msg *WebSocketMessage) error {
+	if wr.Connect == nil {
+		return nil
+	}
+	return wr.Connect(ctx, msg)
+}
+
+//OnDisconnect calls Disconnect if it is set
+func (wr *WebSocketRouter) OnDisconnect(ctx context.Context, msg *WebSocketMessage) error {
+	if wr.Disconnect == nil {
+		return nil
+	}
+	return wr.Disconnect(ctx, msg)
+}
+
+//OnMessage calls the handler for the message's route key
+func (wr *WebSocketRouter) OnMessage(ctx context.Context, msg *WebSocketMessage) (interface{}, error) {
+	handler, ok := wr.routes[msg.RouteKey]
+	if !ok {
+		handler, ok = wr.routes["$default"]
+	}
+	if !ok {
+		return nil, NewHTTPError(http.StatusNotFound, "No route for "+msg.RouteKey, nil)
+	}
+	return handler(ctx, msg)
+}
+
+//withWebSocket is an http handler that also handles websocket events
+type withWebSocket struct {
+	http.Handler
+	ws WebSocketHandler
+}
+
+//WithWebSocket combines an http handler with a websocket handler, so LambdaHandler serves websocket events from the same lambda
+//An http handler that implements WebSocketHandler itself doesn't need wrapping
+func WithWebSocket(handler http.Handler, ws WebSocketHandler) http.Handler {
+	return withWebSocket{Handler: handler, ws: ws}
+}
+
+//webSocketHandlerFor returns the websocket handler of an http handler passed to LambdaHandler, or nil if it has none
+func webSocketHandlerFor(handler http.Handler) WebSocketHandler {
+	switch h := handler.(type) {
+	case withWebSocket:
+		return h.ws
+	case WebSocketHandler:
+		return h
+	}
+	return nil
+}
+
+//ServeWebSocket handles a websocket api event, calling OnConnect, OnDisconnect or OnMessage for its event type
+//Panics in the handler are recovered, logged and answered with a 500
+func ServeWebSocket(ctx context.Context, req events.APIGatewayWebsocketProxyRequest, ws WebSocketHandler) (resp events.APIGatewayProxyResponse, err error) {
+	msg := &WebSocketMessage{
+		ConnectionID: req.RequestContext.ConnectionID,
+		RouteKey:     req.RequestContext.RouteKey,
+		Body:         []byte(req.Body),
+		Event:        req,
+	}
+	if req.IsBase64Encoded {
+		if msg.Body, err = base64.StdEncoding.DecodeString(req.Body); err != nil {
+			return webSocketError(msg, NewHTTPError(http.StatusBadRequest, "Invalid base64 body", err))
+		}
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			stack := debug.Stack()
+			logger.Printf("Recovered panic serving websocket %s %s: %v\n%s", msg.RouteKey, msg.ConnectionID, recovered, stack)
+			logger.NotifyAdmin(fmt.Sprintf("Panic serving websocket %s: %v", msg.RouteKey, recovered), map[string]interface{}{
+				"routeKey":     msg.RouteKey,
+				"connectionId": msg.ConnectionID,
+				"requestId":    req.RequestContext.RequestID,
+				"panic":        fmt.Sprint(recovered),
+				"stack":        string(stack),
+			})
+			resp, err = webSocketProblem(Problem{
+				Type:   "about:blank",
+				Title:  http.StatusText(http.StatusInternalServerError),
+				Status: http.StatusInternalServerError,
+			}), nil
+		}
+	}()
+	var body interface{}
+	switch req.RequestContext.EventType {
+	case "CONNECT":
+		if err = ws.OnConnect(ctx, msg); err == nil {
+			if tracker, ok := connectionManager.(connectionTracker); ok {
+				tracker.Connected(msg.ConnectionID)
+			}
+		}
+	case "DISCONNECT":
+		err = ws.OnDisconnect(ctx, msg)
+		if tracker, ok := connectionManager.(connectionTracker); ok {
+			tracker.Disconnected(msg.ConnectionID)
+		}
+	case "MESSAGE":
+		body, err = ws.OnMessage(ctx, msg)
+	default:
+		err = NewHTTPError(http.StatusBadRequest, "Unknown websocket event type "+req.RequestContext.EventType, nil)
+	}
+	if err != nil {
+		return webSocketError(msg, err)
+	}
+	resp = events.APIGatewayProxyResponse{StatusCode: http.StatusOK}
+	switch b := body.(type) {
+	case nil:
+	case string:
+		resp.Body = b
+	case []byte:
+		resp.Body = string(b)
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			return webSocketError(msg, err)
+		}
+		resp.Body = string(raw)
+	}
+	return resp, nil
+}
+
+//webSocketError responds with the problem document for err, the status rejects a connection when answering $connect
+func webSocketError(msg *WebSocketMessage, err error) (events.APIGatewayProxyResponse, error) {
+	return webSocketProblem(problemFor(err, http.StatusInternalServerError, msg.RouteKey)), nil
+}
+
+func webSocketProblem(problem Problem) events.APIGatewayProxyResponse {
+	body, err := json.Marshal(problem)
+	if err != nil {
+		logger.Println(err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: problem.Status,
+		Headers:    map[string]string{"Content-Type": problemContentType},
+		Body:       string(body),
+	}
+}
+
+//ErrGoneConnection is returned when posting to or deleting a connection that has closed
+var ErrGoneConnection = errors.New("Connection is gone")
+
+//ErrNoConnectionManager is returned when sending to a connection before SetConnectionManager has been called
+var ErrNoConnectionManager = errors.New("No connection manager set")
+
+//ConnectionManager sends data to and closes websocket connections, such as with the apigateway management api
+type ConnectionManager interface {
+	//PostToConnection sends data to the client, it returns ErrGoneConnection if the client has disconnected
+	PostToConnection(ctx context.Context, connectionID string, data []byte) error
+	//DeleteConnection disconnects the client
+	DeleteConnection(ctx context.Context, connectionID string) error
+}
+
+//connectionTracker is implemented by connection managers that need to know which connections are open
+type connectionTracker interface {
+	Connected(connectionID string)
+	Disconnected(connectionID string)
+}
+
+var connectionManager ConnectionManager
+
+//SetConnectionManager sets the connection manager used by PostToConnection, DeleteConnection and WebSocketMessage.Reply
+func SetConnectionManager(manager ConnectionManager) {
+	connectionManager = manager
+}
+
+//PostToConnection sends data to a websocket connection with the connection manager
+func PostToConnection(ctx context.Context, connectionID string, data []byte) error {
+	if connectionManager == nil {
+		return ErrNoConnectionManager
+	}
+	return connectionManager.PostToConnection(ctx, connectionID, data)
+}
+
+//DeleteConnection disconnects a websocket connection with the connection manager
+func DeleteConnection(ctx context.Context, connectionID string) error {
+	if connectionManager == nil {
+		return ErrNoConnectionManager
+	}
+	return connectionManager.DeleteConnection(ctx, connectionID)
+}
+
+//MemoryConnectionManager is a ConnectionManager that keeps the data sent to each connection in memory, for tests
+//ServeWebSocket adds connections when they are accepted and removes them when they disconnect
+type MemoryConnectionManager struct {
+	mu          sync.Mutex
+	connections map[string][][]byte
+}
+
+//NewMemoryConnectionManager creates a connection manager with no connections
+func NewMemoryConnectionManager() *MemoryConnectionManager {
+	return &MemoryConnectionManager{connections: make(map[string][][]byte)}
+}
+
+//Connected adds an open connection
+func (m *MemoryConnectionManager) Connected(connectionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.connections == nil {
+		m.connections = make(map[string][][]byte)
+	}
+	if _, ok := m.connections[connectionID]; !ok {
+		m.connections[connectionID] = [][]byte{}
+	}
+}
+
+//Disconnected removes a connection and the data sent to it
+func (m *MemoryConnectionManager) Disconnected(connectionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.connections, connectionID)
+}
+
+//PostToConnection records data as sent to an open connection
+func (m *MemoryConnectionManager) PostToConnection(ctx context.Context, connectionID string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent, ok := m.connections[connectionID]
+	if !ok {
+		return ErrGoneConnection
+	}
+	m.connections[connectionID] = append(sent, append([]byte(nil), data...))
+	return nil
+}
+
+//DeleteConnection closes an open connection
+func (m *MemoryConnectionManager) DeleteConnection(ctx context.Context, connectionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.connections[connectionID]; !ok {
+		return ErrGoneConnection
+	}
+	delete(m.connections, connectionID)
+	return nil
+}
+
+//IsConnected reports whether a connection is open
+func (m *MemoryConnectionManager) IsConnected(connectionID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.connections[connectionID]
+	return ok
+}
+
+//Sent returns the data sent to an open connection, in order
+func (m *MemoryConnectionManager) Sent(connectionID string) [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte(nil), m.connections[connectionID]...)
+}
@@ -0,0 +1,112 @@
+package apig_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	apig "github.com/SpalkLtd/apigateway"
+	"github.com/SpalkLtd/apigateway/apigtest"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func chatRouter() *apig.WebSocketRouter {
+	router := apig.NewWebSocketRouter()
+	router.Connect = func(ctx context.Context, msg *apig.WebSocketMessage) error {
+		if msg.Event.QueryStringParameters["token"] != "secret" {
+			return apig.NewHTTPError(http.StatusUnauthorized, "Invalid token", nil)
+		}
+		return nil
+	}
+	return router.
+		Route("sendmessage", func(ctx context.Context, msg *apig.WebSocketMessage) (interface{}, error) {
+			var payload struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(msg.Body, &payload); err != nil {
+				return nil, apig.NewHTTPError(http.StatusBadRequest, "Invalid message", err)
+			}
+			return nil, msg.Reply(ctx, []byte("echo: "+payload.Text))
+		}).
+		Route("whoami", func(ctx context.Context, msg *apig.WebSocketMessage) (interface{}, error) {
+			return map[string]string{"connectionId": msg.ConnectionID}, nil
+		})
+}
+
+func TestServeWebSocket(t *testing.T) {
+	testNotifierLogger()
+	connections := apig.NewMemoryConnectionManager()
+	apig.SetConnectionManager(connections)
+	defer apig.SetConnectionManager(nil)
+	router := chatRouter()
+	ctx := context.Background()
+
+	resp, err := apig.ServeWebSocket(ctx, apigtest.NewWebSocketRequest("$connect", "conn-1").Build(), router)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.False(t, connections.IsConnected("conn-1"))
+
+	resp, err = apig.ServeWebSocket(ctx, apigtest.NewWebSocketRequest("$connect", "conn-1").WithQuery("token", "secret").Build(), router)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, connections.IsConnected("conn-1"))
+
+	resp, err = apig.ServeWebSocket(ctx, apigtest.NewWebSocketRequest("sendmessage", "conn-1").WithJSONBody(map[string]string{"text": "hi"}).Build(), router)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, [][]byte{[]byte("echo: hi")}, connections.Sent("conn-1"))
+
+	resp, err = apig.ServeWebSocket(ctx, apigtest.NewWebSocketRequest("whoami", "conn-1").Build(), router)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"connectionId":"conn-1"}`, resp.Body)
+
+	resp, err = apig.ServeWebSocket(ctx, apigtest.NewWebSocketRequest("sendmessage", "conn-1").WithBase64Body([]byte("not json")).Build(), router)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, "application/problem+json", resp.Headers["Content-Type"])
+
+	resp, err = apig.ServeWebSocket(ctx, apigtest.NewWebSocketRequest("unknown", "conn-1").Build(), router)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = apig.ServeWebSocket(ctx, apigtest.NewWebSocketRequest("$disconnect", "conn-1").Build(), router)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.False(t, connections.IsConnected("conn-1"))
+	require.Equal(t, apig.ErrGoneConnection, apig.PostToConnection(ctx, "conn-1", []byte("late")))
+}
+
+func TestServeWebSocketRecoversPanics(t *testing.T) {
+	tNot := testNotifierLogger()
+	router := apig.NewWebSocketRouter().Route("$default", func(ctx context.Context, msg *apig.WebSocketMessage) (interface{}, error) {
+		panic("nil map")
+	})
+	resp, err := apig.ServeWebSocket(context.Background(), apigtest.NewWebSocketRequest("anything", "conn-1").Build(), router)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.JSONEq(t, `{"type":"about:blank","title":"Internal Server Error","status":500}`, resp.Body)
+	require.True(t, tNot.GotNotification("Panic serving websocket anything: nil map"))
+}
+
+func TestLambdaHandlerDetectsWebSocket(t *testing.T) {
+	testNotifierLogger()
+	httpHandler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("http"))
+	})
+	handler := apig.LambdaHandlerWithContext(apig.WithWebSocket(httpHandler, chatRouter()), nil)
+	ctx := context.Background()
+
+	resp, err := handler(ctx, apigtest.NewWebSocketRequest("whoami", "conn-2").JSON())
+	require.NoError(t, err)
+	require.JSONEq(t, `{"connectionId":"conn-2"}`, resp.(events.APIGatewayProxyResponse).Body)
+
+	resp, err = handler(ctx, apigtest.NewV1Request("GET", "/").JSON())
+	require.NoError(t, err)
+	require.Equal(t, "http", resp.(events.APIGatewayProxyResponse).Body)
+
+	//without a websocket handler the event goes to the fallback
+	_, err = apig.LambdaHandlerWithContext(httpHandler, nil)(ctx, apigtest.NewWebSocketRequest("whoami", "conn-2").JSON())
+	require.Equal(t, apig.ErrNoHandler, err)
+}